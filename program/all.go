@@ -0,0 +1,57 @@
+package program
+
+import (
+	"github.com/alecthomas/kong"
+	"github.com/clouddrove/kuconf/program/aws"
+	"github.com/clouddrove/kuconf/program/azure"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/clouddrove/kuconf/program/gcp"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+// AllCmd syncs every configured cloud in one run
+type AllCmd struct {
+	AWS   aws.Options   `embed:"" prefix:"aws-"`
+	GCP   gcp.Options   `embed:"" prefix:"gcp-"`
+	Azure azure.Options `embed:"" prefix:"azure-"`
+}
+
+// checkable is a provider which can tell whether it has been given enough configuration to run
+type checkable interface {
+	core.Provider
+	Check() error
+}
+
+// Run runs the discovery for every configured provider concurrently and writes the kubeconfig once.  A provider
+// given any of its own flags must be configured, so a mistake in them fails the run instead of skipping the cloud.
+func (cmd *AllCmd) Run(ctx *kong.Context, options *core.Options) error {
+	var providers []core.Provider
+
+	for _, p := range []checkable{&cmd.AWS, &cmd.GCP, &cmd.Azure} {
+		if err := p.Check(); err == nil {
+			providers = append(providers, p)
+		} else if flag := givenFlag(ctx, p.Name()+"-"); flag != "" {
+			return errors.Wrapf(err, "%s provider isn't configured despite --%s", p.Name(), flag)
+		} else {
+			log.Debug().Err(err).Str("provider", p.Name()).Msg("Provider is not configured, skipping")
+		}
+	}
+
+	if len(providers) < 1 {
+		return errors.New("No providers are configured")
+	}
+
+	return options.Sync(providers...)
+}
+
+// givenFlag returns the first flag with the prefix given on the command line, or an empty string
+func givenFlag(ctx *kong.Context, prefix string) string {
+	for _, path := range ctx.Path {
+		if path.Flag != nil && strings.HasPrefix(path.Flag.Name, prefix) {
+			return path.Flag.Name
+		}
+	}
+	return ""
+}
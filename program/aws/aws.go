@@ -1,77 +1,90 @@
 package aws
 
 import (
-	"bufio"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/eks"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"os"
-	"strings"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 type sessionInfo struct {
 	profile string
+	roleArn string
 	account string
 	region  string
 	session *session.Session
 	log     zerolog.Logger
 }
 
-type ClusterInfo struct {
-	*eks.Cluster
-	log     zerolog.Logger
-	session *sessionInfo
-}
+func (s *sessionInfo) Account() string        { return s.account }
+func (s *sessionInfo) Region() string         { return s.region }
+func (s *sessionInfo) Logger() zerolog.Logger { return s.log }
 
-// getProfiles gets all profiles from ~/.aws/credentials or the program arguement
+// getProfiles gets the profiles from the program arguments, or discovers them from the AWS credentials and config files
 func (program *Options) getProfiles() <-chan string {
 	output := make(chan string)
 
-	if len(program.Profiles) < 1 {
-		go func() {
-			defer close(output)
-			if f, err := os.Open(program.CredentialsFile); err == nil {
-				scanner := bufio.NewScanner(f)
-				scanner.Split(bufio.ScanLines)
-
-				for scanner.Scan() {
-					s := strings.TrimSpace(scanner.Text())
-					if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
-						output <- s[1 : len(s)-1]
-					}
-				}
+	go func() {
+		defer close(output)
 
-			} else {
-				stats.Errors.Add(1)
-				log.Error().Str("file", program.CredentialsFile).Err(err).Msg("Failed to open file")
-			}
-		}()
-	} else {
-		go func() {
-			defer close(output)
-			for _, p := range program.Profiles {
-				output <- p
-			}
-		}()
-	}
+		profiles := program.Profiles
+		if len(profiles) < 1 {
+			profiles = program.discoverProfiles()
+		}
+
+		for _, p := range profiles {
+			output <- p
+		}
+	}()
 
 	return output
 }
 
-// getClustersFrom gets the clusters from
-func (program *Options) getClustersFrom(s *sessionInfo, clusters chan<- ClusterInfo) {
+// sessionOptions are the SDK options for a session using the profile in the region.  Shared config is always enabled
+// so that role chaining, SSO and credential_process profiles from the config file work.  The credentials file comes
+// last, so it overrides the config file as it does for the SDK and the AWS CLI.
+func (program *Options) sessionOptions(profile, region string) session.Options {
+	return session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+		SharedConfigFiles: []string{program.ConfigFile, program.CredentialsFile},
+		Config: aws.Config{
+			Region:  aws.String(region),
+			Retryer: program.retryer(),
+		},
+	}
+}
+
+// Clusters gets the clusters from the session, following every page of the cluster list
+func (program *Options) Clusters(sess core.Session, clusters chan<- core.Cluster) error {
+	s := sess.(*sessionInfo)
+
 	wg := sync.WaitGroup{}
-	defer wg.Wait()
+	var failed atomic.Bool
 
 	e := eks.New(s.session)
+	input := &eks.ListClustersInput{}
 
 	s.log.Debug().Msg("Listing EKS clusters")
-	if out, err := e.ListClusters(&eks.ListClustersInput{}); err == nil {
-		s.log.Debug().Msg("Getting Clusters")
+	for {
+		var out *eks.ListClustersOutput
+		err := program.call(func(ctx aws.Context) (err error) {
+			out, err = e.ListClustersWithContext(ctx, input)
+			return
+		})
+		if err != nil {
+			wg.Wait()
+			stats.Errors.Add(1)
+			s.log.Error().Err(err).Msg("Error listing clusters")
+			return err
+		}
 
 		stats.Clusters.Add(int32(len(out.Clusters)))
 
@@ -81,70 +94,70 @@ func (program *Options) getClustersFrom(s *sessionInfo, clusters chan<- ClusterI
 				defer wg.Done()
 				s.log.Debug().Str("cluster_name", *c).Msg("Found cluster")
 
-				if out, err := e.DescribeCluster(&eks.DescribeClusterInput{Name: c}); err != nil {
+				var out *eks.DescribeClusterOutput
+				err := program.call(func(ctx aws.Context) (err error) {
+					out, err = e.DescribeClusterWithContext(ctx, &eks.DescribeClusterInput{Name: c})
+					return
+				})
+				if err != nil {
 					stats.Errors.Add(1)
-					log.Error().Err(err).Msg("Error describing cluster")
+					failed.Store(true)
+					s.log.Error().Err(err).Str("cluster_name", *c).Msg("Error describing cluster")
 				} else {
 					log.Info().Str("cluster_name", *c).Str("Profile", s.profile).Str("Region", s.region).Str("Account", s.account).Msg("Cluster config downloaded for")
-					clusters <- ClusterInfo{
-						Cluster: out.Cluster,
-						log:     s.log.With().Str("cluster_name", *c).Logger(),
-						session: s,
+					clusters <- core.Cluster{
+						Provider: program.Name(),
+						Account:  s.account,
+						Region:   s.region,
+						Name:     *c,
+						ID:       aws.StringValue(out.Cluster.Arn),
+						Tags:     aws.StringValueMap(out.Cluster.Tags),
+						Status:   aws.StringValue(out.Cluster.Status),
+						Session:  s,
+						Log:      s.log.With().Str("cluster_name", *c).Logger(),
+						Detail:   out.Cluster,
 					}
 				}
 			}(c)
 		}
-	} else {
-		stats.Errors.Add(1)
-		s.log.Error().Err(err).Msg("Error listing clusters")
+
+		if aws.StringValue(out.NextToken) == "" {
+			break
+		}
+		input.NextToken = out.NextToken
 	}
-}
 
-func (program *Options) getUniqueSessions() <-chan *sessionInfo {
+	wg.Wait()
+	if failed.Load() {
+		return errors.New("Failed to describe all clusters")
+	}
+	return nil
+}
 
-	sessions := make(chan *sessionInfo)
+// Expand creates a session in every region for the account, using the same credentials as the account's session
+func (program *Options) Expand(sess core.Session) <-chan core.Session {
+	info := sess.(*sessionInfo)
+	sessions := make(chan core.Session)
 
 	go func() {
-		wg := sync.WaitGroup{}
-
 		defer close(sessions)
-		defer wg.Wait()
-
-		stats.Regions.Add(int32(len(program.Regions)))
-
-		accounts := make(map[string]string)
-		for info := range program.getProfileSessions() {
-			if _, found := accounts[info.account]; found {
-				info.log.Debug().Msg("Profile is duplicate")
-			} else {
-				info.log.Debug().Msg("Profile is good for use")
-				accounts[info.account] = info.profile
-
-				stats.UniqueProfiles.Add(1)
-				sessions <- info
-
-				for _, region := range program.Regions {
-					wg.Add(1)
-					go func(profile, region, account string, info *sessionInfo) {
-						defer wg.Done()
-						if region != info.region {
-							log := log.With().Str("profile", info.profile).Str("region", region).Logger()
-							log.Debug().Msg("Creating regional session")
-							if s, err := session.NewSessionWithOptions(session.Options{Profile: profile, Config: aws.Config{Region: aws.String(region)}}); err == nil {
-								sessions <- &sessionInfo{
-									profile: profile,
-									region:  region,
-									account: account,
-									session: s,
-									log:     log,
-								}
-							} else {
-								stats.Errors.Add(1)
-								log.Error().Err(err).Msg("Failed to create session")
-							}
-						}
-					}(info.profile, region, info.account, info)
-				}
+
+		sessions <- info
+
+		for _, region := range program.regionsFor(info) {
+			if region == info.region {
+				continue
+			}
+
+			log := info.log.With().Str("region", region).Logger()
+			log.Debug().Msg("Creating regional session")
+			sessions <- &sessionInfo{
+				profile: info.profile,
+				roleArn: info.roleArn,
+				region:  region,
+				account: info.account,
+				session: info.session.Copy(&aws.Config{Region: aws.String(region)}),
+				log:     log,
 			}
 		}
 	}()
@@ -152,38 +165,93 @@ func (program *Options) getUniqueSessions() <-chan *sessionInfo {
 	return sessions
 }
 
-// getProfileSessions gets a channel for a session for the first region for each profile, and fills in the account ID for that profile.
-func (program *Options) getProfileSessions() <-chan *sessionInfo {
+// Accounts gets a channel for a session for the first region for each account.  When several profiles reach the same
+// account, the preferred one is chosen; the others are logged as rejected.
+func (program *Options) Accounts() <-chan core.Session {
 
-	sessions := make(chan *sessionInfo)
-	wg := sync.WaitGroup{}
+	sessions := make(chan core.Session)
 
 	go func() {
 		defer close(sessions)
-		defer wg.Wait()
 
-		profiles := program.getProfiles()
-
-		for p := range profiles {
-			log := log.With().Str("profile", p).Str("region", program.Regions[0]).Logger()
-			stats.Profiles.Add(1)
-			wg.Add(1)
-			go func(p string) {
-				defer wg.Done()
-				if s, err := NewSession(p, program.Regions[0], log); err == nil {
-					stats.UsableProfiles.Add(1)
-					sessions <- s
-				}
-			}(p)
+		if program.OrgRole != "" {
+			program.orgSessions(sessions)
+			return
+		}
 
+		for _, s := range program.chooseProfiles(program.profileSessions()) {
+			sessions <- s
 		}
 	}()
 
 	return sessions
 }
 
-func NewSession(profile, region string, log zerolog.Logger) (*sessionInfo, error) {
-	if sess, err := session.NewSessionWithOptions(session.Options{Profile: profile, Config: aws.Config{Region: aws.String(region)}}); err == nil {
+// profileSessions creates a session for every profile, grouped by account
+func (program *Options) profileSessions() map[string][]*sessionInfo {
+	accounts := make(map[string][]*sessionInfo)
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for p := range program.getProfiles() {
+		log := log.With().Str("profile", p).Str("region", program.homeRegion()).Logger()
+		stats.Accounts.Add(1)
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			if s, err := program.NewSession(p, program.homeRegion(), log); err == nil {
+				stats.UsableAccounts.Add(1)
+				mutex.Lock()
+				accounts[s.account] = append(accounts[s.account], s)
+				mutex.Unlock()
+			}
+		}(p)
+	}
+
+	wg.Wait()
+	return accounts
+}
+
+// chooseProfiles picks the preferred session for each account, in account order
+func (program *Options) chooseProfiles(accounts map[string][]*sessionInfo) []*sessionInfo {
+	config, err := program.readSharedConfig()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read AWS shared config, profile preference ignores credential types")
+	}
+	ranker := program.profileRanker(config)
+
+	var chosen []*sessionInfo
+	for _, account := range sortedAccounts(accounts) {
+		candidates := accounts[account]
+		rule := ranker.choose(candidates)
+		if rule == "" {
+			rule = "only profile"
+		}
+		s := candidates[0]
+
+		rejected := make([]string, 0, len(candidates)-1)
+		for _, r := range candidates[1:] {
+			rejected = append(rejected, r.profile)
+		}
+		s.log.Debug().Strs("rejected_profiles", rejected).Str("rule", rule).Msg("Chose profile for account")
+		stats.AddChoice(core.CredentialChoice{Account: account, Chosen: s.profile, Rejected: rejected, Rule: rule})
+
+		chosen = append(chosen, s)
+	}
+	return chosen
+}
+
+func sortedAccounts(accounts map[string][]*sessionInfo) []string {
+	keys := make([]string, 0, len(accounts))
+	for k := range accounts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (program *Options) NewSession(profile, region string, log zerolog.Logger) (*sessionInfo, error) {
+	if sess, err := session.NewSessionWithOptions(program.sessionOptions(profile, region)); err == nil {
 		svc := sts.New(sess)
 		if out, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{}); err == nil {
 			log := log.With().Str("account", *out.Account).Logger()
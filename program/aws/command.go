@@ -0,0 +1,40 @@
+package aws
+
+import (
+	"github.com/clouddrove/kuconf/program/core"
+	"os"
+)
+
+// Command is the aws command.  It syncs the EKS clusters unless a subcommand is given.
+type Command struct {
+	Options `embed:""`
+
+	Sync  SyncCmd  `cmd:"" default:"withargs" help:"Download kubeconfigs for EKS clusters across multiple profiles and regions (the default)"`
+	Token TokenCmd `cmd:"" help:"Print an ExecCredential with a token for an EKS cluster.  Used by kubeconfigs written with --auth-mode=kuconf"`
+}
+
+// SyncCmd syncs the EKS clusters
+type SyncCmd struct{}
+
+func (cmd *SyncCmd) Run(program *Command, options *core.Options) error {
+	if err := program.Check(); err != nil {
+		return err
+	}
+	return options.Sync(&program.Options)
+}
+
+// TokenCmd prints a token for kubectl
+type TokenCmd struct {
+	ClusterName string `help:"Name of the EKS cluster" required:""`
+	Region      string `help:"Region of the EKS cluster" required:""`
+}
+
+// BeforeApply keeps the log off stdout, which is read by kubectl
+func (cmd *TokenCmd) BeforeApply(options *core.Options) error {
+	options.LogToStderr = true
+	return nil
+}
+
+func (cmd *TokenCmd) Run(program *Command) error {
+	return program.WriteToken(os.Stdout, cmd.ClusterName, cmd.Region)
+}
@@ -2,111 +2,83 @@ package aws
 
 import (
 	"encoding/base64"
-	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
-	"k8s.io/client-go/tools/clientcmd"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/clouddrove/kuconf/program/core"
 	"k8s.io/client-go/tools/clientcmd/api"
-	"os"
 )
 
-func captureConfig(c ClusterInfo, i *api.Config) error {
-	certificateData, err := base64.StdEncoding.DecodeString(*c.CertificateAuthority.Data)
+// Render creates the kubeconfig entry for an EKS cluster
+func (program *Options) Render(c core.Cluster) (*core.Entry, error) {
+	info := c.Detail.(*eks.Cluster)
+	s := c.Session.(*sessionInfo)
+
+	// A cluster which is still being created has neither yet
+	if info.Endpoint == nil || info.CertificateAuthority == nil || info.CertificateAuthority.Data == nil {
+		return nil, &core.SkipError{Reason: "Cluster has no endpoint or certificate authority yet"}
+	}
+
+	certificateData, err := base64.StdEncoding.DecodeString(*info.CertificateAuthority.Data)
 	if err != nil {
-		c.log.Error().Err(err).Msg("Failed to decode certificate authority data from Amazon")
-		stats.Errors.Add(1)
-		return err
+		c.Log.Error().Err(err).Msg("Failed to decode certificate authority data from Amazon")
+		return nil, err
 	}
 
 	cluster := api.Cluster{
-		Server:                   *c.Endpoint,
+		Server:                   *info.Endpoint,
 		CertificateAuthorityData: certificateData,
 	}
 
 	user := api.AuthInfo{
-		Exec: &api.ExecConfig{
-			APIVersion: "client.authentication.k8s.io/v1beta1",
-			Command:    "aws",
-			Args: []string{
-				"--region",
-				c.session.region,
-				"eks",
-				"get-token",
-				"--cluster-name",
-				*c.Name,
-			},
-			Env: []api.ExecEnvVar{
-				{
-					Name:  "AWS_PROFILE",
-					Value: c.session.profile,
-				},
-			},
-		},
-	}
-
-	context := api.Context{
-		Cluster:  *c.Arn,
-		AuthInfo: *c.Arn,
+		Exec: program.exec(s, *info.Name),
 	}
 
-	i.Clusters[*c.Arn] = &cluster
-	i.AuthInfos[*c.Arn] = &user
-	i.Contexts[*c.Name] = &context
-
-	return nil
+	return &core.Entry{
+		Name:         *info.Name,
+		ClusterName:  *info.Arn,
+		AuthInfoName: *info.Arn,
+		Cluster:      &cluster,
+		AuthInfo:     &user,
+		ManagedEnv:   []string{"AWS_PROFILE"},
+	}, nil
 }
 
-func (program *Options) ReadConfig() (*api.Config, error) {
-
-	if _, err := os.Stat(program.KubeConfig); err != nil {
-		c := api.NewConfig()
-		return c, nil
-	} else {
-		c, err := clientcmd.LoadFromFile(program.KubeConfig)
-		if err != nil {
-			return nil, err
-		}
-
-		return c, nil
+// exec is the command kubectl runs to get a token for the cluster, according to the auth mode
+func (program *Options) exec(s *sessionInfo, clusterName string) *api.ExecConfig {
+	roleArn := s.roleArn
+	if program.RoleArn != "" {
+		roleArn = program.RoleArn
 	}
-}
-
-func (program *Options) WriteConfig(config *api.Config) error {
-	newFile := program.KubeConfig + ".tmp"
-	bakFile := program.KubeConfig + ".bak"
 
-	err := clientcmd.WriteToFile(*config, newFile)
+	exec := &api.ExecConfig{
+		APIVersion: "client.authentication.k8s.io/v1beta1",
+	}
 
-	log := log.With().Str("kubeconfig_file", program.KubeConfig).Logger()
+	switch program.AuthMode {
+	case "aws-iam-authenticator":
+		exec.Command = "aws-iam-authenticator"
+		exec.Args = []string{"token", "--cluster-id", clusterName, "--region", s.region}
+		if roleArn != "" {
+			exec.Args = append(exec.Args, "--role", roleArn)
+		}
 
-	if err != nil {
-		return err
-	}
+	case "kuconf":
+		exec.Command = "kuconf"
+		exec.Args = []string{"aws", "token", "--cluster-name", clusterName, "--region", s.region}
+		if roleArn != "" {
+			exec.Args = append(exec.Args, "--role-arn", roleArn)
+		}
 
-	if _, err := os.Stat(bakFile); err == nil {
-		err = os.RemoveAll(bakFile)
-		if err != nil {
-			return errors.Wrap(err, "Failed to remove config backup file")
+	default:
+		exec.Command = "aws"
+		exec.Args = []string{"--region", s.region, "eks", "get-token", "--cluster-name", clusterName}
+		if roleArn != "" {
+			exec.Args = append(exec.Args, "--role-arn", roleArn)
 		}
 	}
 
-	if _, err := os.Stat(program.KubeConfig); err != nil {
-		// There is no current config file, so just
-		log.Debug().Msg("No existing config file.  Copying new to config")
-		return os.Rename(newFile, program.KubeConfig)
+	if !program.NoProfileEnv {
+		exec.Env = []api.ExecEnvVar{{Name: "AWS_PROFILE", Value: s.profile}}
 	}
 
-	if err := os.Rename(program.KubeConfig, bakFile); err == nil {
-		if e2 := os.Rename(newFile, program.KubeConfig); e2 == nil {
-			return nil
-		} else {
-			// There was an error renaming the new file, so restore the bak file
-			if err := os.Rename(bakFile, program.KubeConfig); err != nil {
-				return errors.Wrap(err, "Error restoring kubeconfig.  Backup left in "+bakFile)
-			} else {
-				return errors.Wrap(e2, "Error saving new kubeconfig")
-			}
-		}
-	} else {
-		return err
-	}
+	return exec
 }
@@ -0,0 +1,43 @@
+package aws
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/pkg/errors"
+)
+
+func TestRender(t *testing.T) {
+	program := &Options{AuthMode: "awscli"}
+	s := &sessionInfo{profile: "dev", account: "111111111111", region: "eu-west-1"}
+	arn := "arn:aws:eks:eu-west-1:111111111111:cluster/prod"
+	ca := &eks.Certificate{Data: aws.String(base64.StdEncoding.EncodeToString([]byte("ca")))}
+
+	for _, tc := range []struct {
+		name    string
+		cluster *eks.Cluster
+		skipped bool
+	}{
+		{name: "active", cluster: &eks.Cluster{Name: aws.String("prod"), Arn: aws.String(arn), Endpoint: aws.String("https://prod"), CertificateAuthority: ca}},
+		{name: "creating", cluster: &eks.Cluster{Name: aws.String("prod"), Arn: aws.String(arn), Status: aws.String("CREATING")}, skipped: true},
+		{name: "no certificate data yet", cluster: &eks.Cluster{Name: aws.String("prod"), Arn: aws.String(arn), Endpoint: aws.String("https://prod"),
+			CertificateAuthority: &eks.Certificate{}}, skipped: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, err := program.Render(core.Cluster{Name: "prod", Session: s, Detail: tc.cluster})
+
+			var skip *core.SkipError
+			switch {
+			case tc.skipped && !errors.As(err, &skip):
+				t.Fatalf("got error %v, want the cluster skipped", err)
+			case !tc.skipped && err != nil:
+				t.Fatal(err)
+			case !tc.skipped && (entry.Cluster.Server != "https://prod" || string(entry.Cluster.CertificateAuthorityData) != "ca" || entry.ClusterName != arn):
+				t.Errorf("got cluster %s %+v", entry.ClusterName, entry.Cluster)
+			}
+		})
+	}
+}
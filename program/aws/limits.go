@@ -0,0 +1,55 @@
+package aws
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"sync"
+	"time"
+)
+
+var (
+	workers     chan struct{}
+	workersOnce sync.Once
+)
+
+// retryer is the SDK's retryer, which backs off exponentially with jitter on throttling and transient errors, with
+// the retries counted in the statistics
+type retryer struct {
+	client.DefaultRetryer
+}
+
+// RetryRules counts the retry and returns the delay before it
+func (r retryer) RetryRules(req *request.Request) time.Duration {
+	stats.Retries.Add(1)
+	if request.IsErrorThrottle(req.Error) {
+		stats.Throttled.Add(1)
+	}
+	return r.DefaultRetryer.RetryRules(req)
+}
+
+// retryer creates the retryer for the program's sessions
+func (program *Options) retryer() request.Retryer {
+	return retryer{client.DefaultRetryer{
+		NumMaxRetries:    program.MaxRetries,
+		MinRetryDelay:    200 * time.Millisecond,
+		MaxRetryDelay:    10 * time.Second,
+		MinThrottleDelay: 500 * time.Millisecond,
+		MaxThrottleDelay: 20 * time.Second,
+	}}
+}
+
+// call runs an API call once a worker is free, with the call timeout.  The workers are shared by all sessions.
+func (program *Options) call(fn func(ctx aws.Context) error) error {
+	workersOnce.Do(func() {
+		workers = make(chan struct{}, max(program.MaxConcurrency, 1))
+	})
+
+	workers <- struct{}{}
+	defer func() { <-workers }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), program.CallTimeout)
+	defer cancel()
+	return fn(ctx)
+}
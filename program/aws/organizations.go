@@ -0,0 +1,107 @@
+package aws
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// orgSessions lists the active accounts of the AWS organization visible to the org profile and creates a session in
+// each of them by assuming the org role
+func (program *Options) orgSessions(sessions chan<- core.Session) {
+	region := program.homeRegion()
+	log := log.With().Str("profile", program.OrgProfile).Str("region", region).Logger()
+
+	sess, err := session.NewSessionWithOptions(program.sessionOptions(program.OrgProfile, region))
+	if err != nil {
+		stats.Errors.Add(1)
+		log.Error().Err(err).Msg("Failed to create session for the organization profile")
+		return
+	}
+
+	var identity *sts.GetCallerIdentityOutput
+	err = program.call(func(ctx aws.Context) (err error) {
+		identity, err = sts.New(sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+		return
+	})
+	if err != nil {
+		stats.Errors.Add(1)
+		log.Error().Err(err).Msg("Error reaching AWS")
+		return
+	}
+
+	partition := "aws"
+	if a, err := arn.Parse(*identity.Arn); err == nil {
+		partition = a.Partition
+	}
+
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	log.Debug().Msg("Listing organization accounts")
+	err = program.call(func(ctx aws.Context) error {
+		return organizations.New(sess).ListAccountsPagesWithContext(ctx, &organizations.ListAccountsInput{},
+			func(page *organizations.ListAccountsOutput, _ bool) bool {
+				for _, account := range page.Accounts {
+					if aws.StringValue(account.Status) != organizations.AccountStatusActive {
+						log.Debug().Str("account", aws.StringValue(account.Id)).Str("status", aws.StringValue(account.Status)).Msg("Skipping inactive account")
+						continue
+					}
+
+					stats.Accounts.Add(1)
+					roleArn := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, aws.StringValue(account.Id), program.OrgRole)
+
+					wg.Add(1)
+					go func(roleArn string) {
+						defer wg.Done()
+						log := log.With().Str("role_arn", roleArn).Logger()
+						if s, err := program.assumeRole(sess, roleArn, log); err == nil {
+							stats.UsableAccounts.Add(1)
+							sessions <- s
+						}
+					}(roleArn)
+				}
+				return true
+			})
+	})
+
+	if err != nil {
+		stats.Errors.Add(1)
+		log.Error().Err(err).Msg("Error listing organization accounts")
+	}
+}
+
+// assumeRole creates a session in an account of the organization by assuming the role from the org profile's session
+func (program *Options) assumeRole(base *session.Session, roleArn string, log zerolog.Logger) (*sessionInfo, error) {
+	sess := base.Copy(&aws.Config{Credentials: stscreds.NewCredentials(base, roleArn)})
+
+	var out *sts.GetCallerIdentityOutput
+	err := program.call(func(ctx aws.Context) (err error) {
+		out, err = sts.New(sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+		return
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to assume organization role")
+		return nil, err
+	}
+
+	log = log.With().Str("account", *out.Account).Logger()
+	log.Debug().Msg("Assumed role in account")
+
+	return &sessionInfo{
+		profile: program.OrgProfile,
+		roleArn: roleArn,
+		region:  aws.StringValue(base.Config.Region),
+		account: *out.Account,
+		session: sess,
+		log:     log,
+	}, nil
+}
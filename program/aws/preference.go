@@ -0,0 +1,104 @@
+package aws
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var readOnly = regexp.MustCompile(`(?i)read-?only`)
+
+// profileRanker orders the profiles which reach the same account, most preferred first
+type profileRanker struct {
+	rules    []string
+	profiles map[string]int
+	regexes  []*regexp.Regexp
+	config   *sharedConfig
+}
+
+// profileRanker creates the ranker for the program's preference rules
+func (program *Options) profileRanker(config *sharedConfig) *profileRanker {
+	r := &profileRanker{
+		rules:    program.ProfilePreference,
+		profiles: make(map[string]int),
+		regexes:  program.PreferProfileRegex,
+		config:   config,
+	}
+	for i, p := range program.Profiles {
+		if _, found := r.profiles[p]; !found {
+			r.profiles[p] = i
+		}
+	}
+	return r
+}
+
+// rank is the position of the profile for one rule, lower is preferred
+func (r *profileRanker) rank(rule, profile string) int {
+	switch rule {
+	case "profiles":
+		if i, found := r.profiles[profile]; found {
+			return i
+		}
+		return len(r.profiles)
+
+	case "regex":
+		for i, re := range r.regexes {
+			if re.MatchString(profile) {
+				return i
+			}
+		}
+		return len(r.regexes)
+
+	case "readonly":
+		if readOnly.MatchString(profile) || readOnly.MatchString(r.setting(profile, "role_arn")) {
+			return 0
+		}
+		return 1
+
+	case "sso":
+		switch {
+		case r.setting(profile, "sso_session") != "", r.setting(profile, "sso_start_url") != "":
+			return 0
+		case r.setting(profile, "role_arn") != "":
+			return 1
+		case r.setting(profile, "credential_process") != "":
+			return 2
+		case r.setting(profile, "aws_access_key_id") != "":
+			return 3
+		default:
+			return 4
+		}
+	}
+	return 0
+}
+
+func (r *profileRanker) setting(profile, key string) string {
+	if r.config == nil {
+		return ""
+	}
+	return r.config.profiles[profile][key]
+}
+
+// compare orders two profiles by the first rule that tells them apart, falling back to the profile names.  It also
+// returns the rule which decided.
+func (r *profileRanker) compare(a, b string) (int, string) {
+	for _, rule := range r.rules {
+		if d := r.rank(rule, a) - r.rank(rule, b); d != 0 {
+			return d, rule
+		}
+	}
+	return strings.Compare(a, b), "name"
+}
+
+// choose sorts the sessions for one account by preference, and returns the rule which chose the first one
+func (r *profileRanker) choose(sessions []*sessionInfo) string {
+	slices.SortFunc(sessions, func(a, b *sessionInfo) int {
+		d, _ := r.compare(a.profile, b.profile)
+		return d
+	})
+	if len(sessions) < 2 {
+		return ""
+	}
+	_, rule := r.compare(sessions[0].profile, sessions[1].profile)
+	return rule
+}
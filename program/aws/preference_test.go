@@ -0,0 +1,112 @@
+package aws
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// testRanker is a ranker for the rules with credentials for the profiles: admin uses static keys, ops and ops-readonly
+// assume roles, and sso signs in through IAM Identity Center
+func testRanker(rules string, profiles []string, regexes ...string) *profileRanker {
+	program := &Options{ProfilePreference: strings.Split(rules, ","), Profiles: profiles}
+	for _, re := range regexes {
+		program.PreferProfileRegex = append(program.PreferProfileRegex, regexp.MustCompile(re))
+	}
+	return program.profileRanker(&sharedConfig{profiles: map[string]map[string]string{
+		"admin":        {"aws_access_key_id": "AKIA"},
+		"ops":          {"role_arn": "arn:aws:iam::1:role/Admin", "source_profile": "admin"},
+		"ops-readonly": {"role_arn": "arn:aws:iam::1:role/Admin", "source_profile": "admin"},
+		"viewer":       {"role_arn": "arn:aws:iam::1:role/ReadOnly", "source_profile": "admin"},
+		"sso":          {"sso_session": "corp"},
+		"process":      {"credential_process": "get-creds"},
+	}})
+}
+
+func TestProfileRankerCompare(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		ranker   *profileRanker
+		a, b     string
+		preferA  bool
+		wantRule string
+	}{
+		{name: "listed profile", ranker: testRanker("profiles", []string{"ops", "admin"}), a: "ops", b: "admin", preferA: true, wantRule: "profiles"},
+		{name: "listed order", ranker: testRanker("profiles", []string{"ops", "admin"}), a: "admin", b: "ops", wantRule: "profiles"},
+		{name: "listed beats unlisted", ranker: testRanker("profiles", []string{"sso"}), a: "sso", b: "admin", preferA: true, wantRule: "profiles"},
+		{name: "unlisted falls back to name", ranker: testRanker("profiles", []string{"sso"}), a: "admin", b: "ops", preferA: true, wantRule: "name"},
+		{name: "first regex", ranker: testRanker("regex", nil, "^ops", "^admin$"), a: "ops", b: "admin", preferA: true, wantRule: "regex"},
+		{name: "second regex", ranker: testRanker("regex", nil, "^ops", "^admin$"), a: "admin", b: "sso", preferA: true, wantRule: "regex"},
+		{name: "readonly name", ranker: testRanker("readonly", nil), a: "ops-readonly", b: "ops", preferA: true, wantRule: "readonly"},
+		{name: "readonly role", ranker: testRanker("readonly", nil), a: "viewer", b: "admin", preferA: true, wantRule: "readonly"},
+		{name: "sso before role", ranker: testRanker("sso", nil), a: "sso", b: "ops", preferA: true, wantRule: "sso"},
+		{name: "role before process", ranker: testRanker("sso", nil), a: "ops", b: "process", preferA: true, wantRule: "sso"},
+		{name: "process before keys", ranker: testRanker("sso", nil), a: "process", b: "admin", preferA: true, wantRule: "sso"},
+		{name: "rules in order", ranker: testRanker("readonly,sso", nil), a: "viewer", b: "sso", preferA: true, wantRule: "readonly"},
+		{name: "later rule decides a tie", ranker: testRanker("readonly,sso", nil), a: "sso", b: "admin", preferA: true, wantRule: "sso"},
+		{name: "no rules", ranker: testRanker("", nil), a: "sso", b: "admin", wantRule: "name"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, rule := tc.ranker.compare(tc.a, tc.b)
+			if (d < 0) != tc.preferA || rule != tc.wantRule {
+				t.Errorf("compare(%s, %s) = %d by %s, want %s preferred: %v by %s", tc.a, tc.b, d, rule, tc.a, tc.preferA, tc.wantRule)
+			}
+
+			// The opposite order gives the opposite answer for the same reason
+			if d2, rule2 := tc.ranker.compare(tc.b, tc.a); (d2 < 0) == (d < 0) || rule2 != rule {
+				t.Errorf("compare(%s, %s) = %d by %s isn't the opposite", tc.b, tc.a, d2, rule2)
+			}
+		})
+	}
+}
+
+func TestProfileRankerChoose(t *testing.T) {
+	sessions := func(profiles ...string) []*sessionInfo {
+		var out []*sessionInfo
+		for _, p := range profiles {
+			out = append(out, &sessionInfo{profile: p})
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		name     string
+		ranker   *profileRanker
+		sessions []*sessionInfo
+		want     string
+		wantRule string
+	}{
+		{name: "single profile", ranker: testRanker("profiles,sso", nil), sessions: sessions("admin"), want: "admin"},
+		{name: "default rules", ranker: testRanker("profiles,regex,readonly,sso", nil), sessions: sessions("admin", "ops", "sso", "viewer"),
+			want: "viewer,sso,ops,admin", wantRule: "readonly"},
+		{name: "listed profiles first", ranker: testRanker("profiles,regex,readonly,sso", []string{"admin"}), sessions: sessions("sso", "viewer", "admin"),
+			want: "admin,viewer,sso", wantRule: "profiles"},
+		{name: "regex", ranker: testRanker("profiles,regex,readonly,sso", nil, "^ops$"), sessions: sessions("viewer", "ops", "admin"),
+			want: "ops,viewer,admin", wantRule: "regex"},
+		{name: "names break ties", ranker: testRanker("sso", nil), sessions: sessions("ops-readonly", "ops", "viewer"),
+			want: "ops,ops-readonly,viewer", wantRule: "name"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The choice doesn't depend on the order the sessions were found in
+			for _, order := range [][]*sessionInfo{tc.sessions, reversed(tc.sessions)} {
+				rule := tc.ranker.choose(order)
+
+				var got []string
+				for _, s := range order {
+					got = append(got, s.profile)
+				}
+				if strings.Join(got, ",") != tc.want || rule != tc.wantRule {
+					t.Errorf("got %s by %q, want %s by %q", strings.Join(got, ","), rule, tc.want, tc.wantRule)
+				}
+			}
+		})
+	}
+}
+
+func reversed(sessions []*sessionInfo) []*sessionInfo {
+	out := make([]*sessionInfo, 0, len(sessions))
+	for i := len(sessions) - 1; i >= 0; i-- {
+		out = append(out, sessions[i])
+	}
+	return out
+}
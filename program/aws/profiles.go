@@ -0,0 +1,210 @@
+package aws
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/rs/zerolog/log"
+	"os"
+	"strings"
+	"time"
+)
+
+// sharedConfig is what the AWS shared credentials and config files say about profiles, merged the way the SDK
+// merges them
+type sharedConfig struct {
+	profiles    map[string]map[string]string
+	order       []string
+	ssoSessions map[string]map[string]string
+}
+
+// readSharedConfig reads the profiles from the credentials file and the config file.  A missing file is not an error.
+func (program *Options) readSharedConfig() (*sharedConfig, error) {
+	config := &sharedConfig{
+		profiles:    make(map[string]map[string]string),
+		ssoSessions: make(map[string]map[string]string),
+	}
+
+	if err := config.read(program.ConfigFile, true); err != nil {
+		return nil, err
+	}
+
+	if err := config.read(program.CredentialsFile, false); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// read reads one INI file.  In the config file profiles are written as [profile name] (except [default]); in the
+// credentials file they are just [name].
+func (c *sharedConfig) read(file string, isConfig bool) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	var section map[string]string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		s := strings.TrimSpace(line)
+
+		switch {
+		case s == "" || strings.HasPrefix(s, "#") || strings.HasPrefix(s, ";"):
+			continue
+
+		case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+			section = c.section(strings.TrimSpace(s[1:len(s)-1]), isConfig)
+
+		case section == nil || line[0] == ' ' || line[0] == '\t':
+			// Outside of a useful section, or a nested setting like "s3 =\n  max_concurrent_requests = 10"
+			continue
+
+		default:
+			if key, value, found := strings.Cut(s, "="); found {
+				section[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// section returns the settings for a section header, creating them if needed.  Sections which are not profiles or
+// SSO sessions return nil.
+func (c *sharedConfig) section(header string, isConfig bool) map[string]string {
+	name := header
+
+	if isConfig {
+		switch {
+		case strings.HasPrefix(header, "sso-session "):
+			name = strings.TrimSpace(strings.TrimPrefix(header, "sso-session "))
+			if c.ssoSessions[name] == nil {
+				c.ssoSessions[name] = make(map[string]string)
+			}
+			return c.ssoSessions[name]
+		case strings.HasPrefix(header, "profile "):
+			name = strings.TrimSpace(strings.TrimPrefix(header, "profile "))
+		case header != "default":
+			return nil
+		}
+	}
+
+	if c.profiles[name] == nil {
+		c.profiles[name] = make(map[string]string)
+		c.order = append(c.order, name)
+	}
+	return c.profiles[name]
+}
+
+// skipReason returns why a profile cannot be used to get credentials, or "" if it looks usable
+func (c *sharedConfig) skipReason(name string) string {
+	return c.checkProfile(name, make(map[string]bool))
+}
+
+func (c *sharedConfig) checkProfile(name string, seen map[string]bool) string {
+	if seen[name] {
+		return "source_profile chain loops back to " + name
+	}
+	seen[name] = true
+
+	p, found := c.profiles[name]
+	if !found {
+		return "profile does not exist"
+	}
+
+	switch {
+	case p["aws_access_key_id"] != "":
+		return ""
+
+	case p["credential_process"] != "":
+		return ""
+
+	case p["role_arn"] != "":
+		switch {
+		case p["source_profile"] != "":
+			source := p["source_profile"]
+			if _, found := c.profiles[source]; !found {
+				return fmt.Sprintf("source_profile %s does not exist", source)
+			}
+			if reason := c.checkProfile(source, seen); reason != "" {
+				return fmt.Sprintf("source_profile %s: %s", source, reason)
+			}
+			return ""
+		case p["credential_source"] != "", p["web_identity_token_file"] != "":
+			return ""
+		default:
+			return "role_arn without source_profile or credential_source"
+		}
+
+	case p["sso_session"] != "":
+		session := p["sso_session"]
+		if _, found := c.ssoSessions[session]; !found {
+			return fmt.Sprintf("sso-session %s does not exist", session)
+		}
+		return checkSSOToken(session, true)
+
+	case p["sso_start_url"] != "":
+		return checkSSOToken(p["sso_start_url"], false)
+
+	default:
+		return "no credentials configured"
+	}
+}
+
+// checkSSOToken checks the SSO token cached by "aws sso login".  Tokens of an sso-session can be refreshed by the SDK,
+// so they are only a problem if they have expired and there is no refresh token.
+func checkSSOToken(key string, refreshable bool) string {
+	path, err := ssocreds.StandardCachedTokenFilepath(key)
+	if err != nil {
+		return err.Error()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "no cached SSO token, run 'aws sso login'"
+	}
+
+	var token struct {
+		AccessToken  string    `json:"accessToken"`
+		ExpiresAt    time.Time `json:"expiresAt"`
+		RefreshToken string    `json:"refreshToken"`
+	}
+
+	if err := json.Unmarshal(data, &token); err != nil || token.AccessToken == "" {
+		return "cached SSO token is unreadable, run 'aws sso login'"
+	}
+
+	if time.Now().After(token.ExpiresAt) && (!refreshable || token.RefreshToken == "") {
+		return fmt.Sprintf("SSO token expired at %s, run 'aws sso login'", token.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return ""
+}
+
+// discoverProfiles returns every usable profile from the shared files, logging the ones skipped and why
+func (program *Options) discoverProfiles() []string {
+	config, err := program.readSharedConfig()
+	if err != nil {
+		stats.Errors.Add(1)
+		log.Error().Err(err).Msg("Failed to read AWS shared config")
+		return nil
+	}
+
+	var profiles []string
+	for _, name := range config.order {
+		if reason := config.skipReason(name); reason != "" {
+			log.Warn().Str("profile", name).Str("reason", reason).Msg("Skipping profile")
+			continue
+		}
+		profiles = append(profiles, name)
+	}
+
+	return profiles
+}
@@ -0,0 +1,204 @@
+package aws
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const testConfigFile = `[default]
+region = eu-west-1
+
+[profile dev]
+aws_access_key_id = CONFIG_KEY
+s3 =
+  max_concurrent_requests = 10
+region = eu-west-2
+
+# Without "profile " this isn't a profile in the config file
+[prod]
+aws_access_key_id = IGNORED
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = eu-west-1
+
+[profile sso]
+sso_session = corp
+sso_account_id = 111111111111
+`
+
+const testCredentialsFile = `[default]
+aws_access_key_id = DEFAULT_KEY
+aws_secret_access_key = secret
+
+[dev]
+aws_access_key_id = CREDENTIALS_KEY
+aws_secret_access_key = secret
+
+; A profile only in the credentials file
+[prod]
+aws_access_key_id = PROD_KEY
+aws_secret_access_key = secret
+`
+
+// writeSharedFiles writes the config and credentials files into a new directory and returns options reading them
+func writeSharedFiles(t *testing.T, config, credentials string) *Options {
+	t.Helper()
+	dir := t.TempDir()
+	program := &Options{
+		ConfigFile:      filepath.Join(dir, "config"),
+		CredentialsFile: filepath.Join(dir, "credentials"),
+	}
+	for path, data := range map[string]string{program.ConfigFile: config, program.CredentialsFile: credentials} {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return program
+}
+
+func TestReadSharedConfig(t *testing.T) {
+	config, err := writeSharedFiles(t, testConfigFile, testCredentialsFile).readSharedConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(config.order, ","); got != "default,dev,sso,prod" {
+		t.Errorf("got profiles %s", got)
+	}
+
+	for _, tc := range []struct {
+		profile, key, want string
+	}{
+		{"default", "region", "eu-west-1"},
+		{"default", "aws_access_key_id", "DEFAULT_KEY"},
+		// The credentials file overrides the config file
+		{"dev", "aws_access_key_id", "CREDENTIALS_KEY"},
+		{"dev", "region", "eu-west-2"},
+		{"dev", "max_concurrent_requests", ""},
+		{"prod", "aws_access_key_id", "PROD_KEY"},
+		{"sso", "sso_session", "corp"},
+	} {
+		if got := config.profiles[tc.profile][tc.key]; got != tc.want {
+			t.Errorf("%s %s = %q, want %q", tc.profile, tc.key, got, tc.want)
+		}
+	}
+
+	if got := config.ssoSessions["corp"]["sso_region"]; got != "eu-west-1" {
+		t.Errorf("got sso-session region %q", got)
+	}
+}
+
+func TestReadSharedConfigMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	program := &Options{ConfigFile: filepath.Join(dir, "config"), CredentialsFile: filepath.Join(dir, "credentials")}
+
+	config, err := program.readSharedConfig()
+	if err != nil || len(config.profiles) != 0 {
+		t.Errorf("got %d profiles and error %v for missing files", len(config.profiles), err)
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// Cached tokens of "aws sso login", named by the SHA1 of the sso-session name or start URL
+	cacheToken := func(key string, expires time.Time, refresh string) {
+		sum := sha1.Sum([]byte(key))
+		path := filepath.Join(home, ".aws", "sso", "cache", hex.EncodeToString(sum[:])+".json")
+		data := `{"accessToken": "token", "expiresAt": "` + expires.UTC().Format(time.RFC3339) + `", "refreshToken": "` + refresh + `"}`
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cacheToken("valid", time.Now().Add(time.Hour), "")
+	cacheToken("refreshable", time.Now().Add(-time.Hour), "refresh")
+	cacheToken("https://expired.awsapps.com/start", time.Now().Add(-time.Hour), "")
+
+	config := &sharedConfig{
+		profiles: map[string]map[string]string{
+			"keys":            {"aws_access_key_id": "AKIA"},
+			"process":         {"credential_process": "get-creds"},
+			"chained":         {"role_arn": "arn:aws:iam::1:role/a", "source_profile": "keys"},
+			"chained-twice":   {"role_arn": "arn:aws:iam::1:role/b", "source_profile": "chained"},
+			"missing-source":  {"role_arn": "arn:aws:iam::1:role/a", "source_profile": "nope"},
+			"bad-source":      {"role_arn": "arn:aws:iam::1:role/a", "source_profile": "empty"},
+			"loop-a":          {"role_arn": "arn:aws:iam::1:role/a", "source_profile": "loop-b"},
+			"loop-b":          {"role_arn": "arn:aws:iam::1:role/b", "source_profile": "loop-a"},
+			"self":            {"role_arn": "arn:aws:iam::1:role/a", "source_profile": "self"},
+			"instance-role":   {"role_arn": "arn:aws:iam::1:role/a", "credential_source": "Ec2InstanceMetadata"},
+			"web-identity":    {"role_arn": "arn:aws:iam::1:role/a", "web_identity_token_file": "/token"},
+			"role-only":       {"role_arn": "arn:aws:iam::1:role/a"},
+			"sso-valid":       {"sso_session": "valid"},
+			"sso-refreshable": {"sso_session": "refreshable"},
+			"sso-logged-out":  {"sso_session": "logged-out"},
+			"sso-missing":     {"sso_session": "nope"},
+			"sso-legacy":      {"sso_start_url": "https://expired.awsapps.com/start"},
+			"empty":           {},
+		},
+		ssoSessions: map[string]map[string]string{
+			"valid":       {},
+			"refreshable": {},
+			"logged-out":  {},
+		},
+	}
+
+	for _, tc := range []struct {
+		profile, want string
+	}{
+		{"keys", ""},
+		{"process", ""},
+		{"chained", ""},
+		{"chained-twice", ""},
+		{"missing-source", "source_profile nope does not exist"},
+		{"bad-source", "source_profile empty: no credentials configured"},
+		{"loop-a", "source_profile loop-b: source_profile loop-a: source_profile chain loops back to loop-a"},
+		{"self", "source_profile self: source_profile chain loops back to self"},
+		{"instance-role", ""},
+		{"web-identity", ""},
+		{"role-only", "role_arn without source_profile or credential_source"},
+		{"sso-valid", ""},
+		{"sso-refreshable", ""},
+		{"sso-logged-out", "no cached SSO token, run 'aws sso login'"},
+		{"sso-missing", "sso-session nope does not exist"},
+		{"sso-legacy", "SSO token expired at"},
+		{"empty", "no credentials configured"},
+		{"unknown", "profile does not exist"},
+	} {
+		got := config.skipReason(tc.profile)
+		if (tc.want == "" && got != "") || !strings.HasPrefix(got, tc.want) {
+			t.Errorf("skipReason(%s) = %q, want %q", tc.profile, got, tc.want)
+		}
+	}
+}
+
+func TestSessionOptionsPrecedence(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+		t.Setenv(name, "")
+	}
+	program := writeSharedFiles(t, "[profile dev]\naws_access_key_id = CONFIG_KEY\naws_secret_access_key = secret\n",
+		"[dev]\naws_access_key_id = CREDENTIALS_KEY\naws_secret_access_key = secret\n")
+
+	sess, err := session.NewSessionWithOptions(program.sessionOptions("dev", "eu-west-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "CREDENTIALS_KEY" {
+		t.Errorf("got access key %s, want the one from the credentials file", creds.AccessKeyID)
+	}
+}
@@ -0,0 +1,123 @@
+package aws
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/clouddrove/kuconf/program/core"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AutoRegions is the --regions value which discovers the regions enabled for each account
+const AutoRegions = "auto"
+
+// DefaultRegions are the regions checked by default, and the fallback when regions can't be discovered
+const DefaultRegions = "us-east-1,us-east-2,us-west-1,us-west-2,ap-south-1,ap-northeast-3,ap-northeast-2,ap-southeast-1,ap-southeast-2,ap-northeast-1,ca-central-1,eu-central-1,eu-west-1,eu-west-2,eu-west-3,eu-north-1,sa-east-1"
+
+// regionCache is the cache of the regions enabled in each account, kept between runs
+type regionCache struct {
+	mutex   sync.Mutex
+	loaded  bool
+	Updated map[string]time.Time `json:"updated"`
+	Regions map[string][]string  `json:"regions"`
+}
+
+var regionsCache = regionCache{}
+
+// autoRegions returns true when the regions are to be discovered for each account
+func (program *Options) autoRegions() bool {
+	return len(program.Regions) == 1 && program.Regions[0] == AutoRegions
+}
+
+// homeRegion is the region in which each account's first session is created
+func (program *Options) homeRegion() string {
+	if program.autoRegions() {
+		return strings.Split(DefaultRegions, ",")[0]
+	}
+	return program.Regions[0]
+}
+
+// regionsFor gets the regions to scan in the session's account
+func (program *Options) regionsFor(s *sessionInfo) []string {
+	if !program.autoRegions() {
+		return program.Regions
+	}
+
+	if regions, ok := regionsCache.get(program.RegionsCache, s.account, program.RegionsCacheTTL); ok {
+		s.log.Debug().Strs("regions", regions).Msg("Using cached regions")
+		return regions
+	}
+
+	var out *ec2.DescribeRegionsOutput
+	err := program.call(func(ctx aws.Context) (err error) {
+		out, err = ec2.New(s.session).DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
+		return
+	})
+	if err != nil {
+		s.log.Warn().Err(err).Msg("Unable to discover regions, using the default regions")
+		return strings.Split(DefaultRegions, ",")
+	}
+
+	regions := make([]string, 0, len(out.Regions))
+	for _, r := range out.Regions {
+		regions = append(regions, aws.StringValue(r.RegionName))
+	}
+	sort.Strings(regions)
+	s.log.Debug().Strs("regions", regions).Msg("Discovered regions")
+
+	if err := regionsCache.put(program.RegionsCache, s.account, regions); err != nil {
+		s.log.Warn().Err(err).Str("file", program.RegionsCache).Msg("Unable to save the regions cache")
+	}
+	return regions
+}
+
+// get returns the cached regions for the account, if they are younger than the ttl
+func (c *regionCache) get(path, account string, ttl time.Duration) ([]string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.load(path)
+	if updated, ok := c.Updated[account]; !ok || time.Since(updated) > ttl {
+		return nil, false
+	}
+	return c.Regions[account], true
+}
+
+// put caches the regions for the account and saves the cache
+func (c *regionCache) put(path, account string, regions []string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.load(path)
+	c.Regions[account] = regions
+	c.Updated[account] = time.Now()
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return core.WriteFileAtomic(path, data)
+}
+
+// load reads the cache file the first time it's needed.  A missing or unreadable cache is treated as empty.
+func (c *regionCache) load(path string) {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	c.Updated = map[string]time.Time{}
+	c.Regions = map[string][]string{}
+
+	if data, err := os.ReadFile(filepath.Clean(path)); err == nil {
+		_ = json.Unmarshal(data, c)
+	}
+	if c.Updated == nil || c.Regions == nil {
+		c.Updated = map[string]time.Time{}
+		c.Regions = map[string][]string{}
+	}
+}
@@ -0,0 +1,64 @@
+package aws
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthentication "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+	"time"
+)
+
+const (
+	tokenPrefix     = "k8s-aws-v1."
+	clusterIDHeader = "x-k8s-aws-id"
+
+	// EKS accepts a token for 15 minutes after it was signed, whatever the presigned expiry says.  The token is
+	// reported as expiring a minute early so that kubectl asks for a new one in time.
+	tokenLifetime = 14 * time.Minute
+)
+
+// WriteToken writes an ExecCredential for the cluster, holding a presigned STS GetCallerIdentity request in the same
+// form as "aws eks get-token".  Credentials come from AWS_PROFILE or the ambient credentials, and --role-arn is
+// assumed if given.
+func (program *Options) WriteToken(out io.Writer, clusterName, region string) error {
+	options := program.sessionOptions("", region)
+	options.Config.STSRegionalEndpoint = endpoints.RegionalSTSEndpoint
+
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create session")
+	}
+
+	config := &aws.Config{}
+	if program.RoleArn != "" {
+		config.Credentials = stscreds.NewCredentials(sess, program.RoleArn)
+	}
+
+	req, _ := sts.New(sess, config).GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	req.HTTPRequest.Header.Add(clusterIDHeader, clusterName)
+
+	url, err := req.Presign(60 * time.Second)
+	if err != nil {
+		return errors.Wrap(err, "Failed to presign token request")
+	}
+
+	credential := clientauthentication.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: clientauthentication.SchemeGroupVersion.String(),
+			Kind:       "ExecCredential",
+		},
+		Status: &clientauthentication.ExecCredentialStatus{
+			Token:               tokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(url)),
+			ExpirationTimestamp: &metav1.Time{Time: time.Now().Add(tokenLifetime)},
+		},
+	}
+
+	return json.NewEncoder(out).Encode(credential)
+}
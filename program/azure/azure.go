@@ -3,13 +3,13 @@ package azure
 import (
 	"bufio"
 	"context"
-	"fmt"
 	"os"
 	"strings"
 	"sync"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -18,19 +18,53 @@ type azureSessionInfo struct {
 	subscription string
 	location     string
 	client       *armcontainerservice.ManagedClustersClient
+	listing      *clusterListing
+	partial      bool
 	log          zerolog.Logger
 }
 
-type AzureClusterInfo struct {
-	*armcontainerservice.ManagedCluster
-	log     zerolog.Logger
-	session *azureSessionInfo
+func (s *azureSessionInfo) Account() string        { return s.subscription }
+func (s *azureSessionInfo) Region() string         { return s.location }
+func (s *azureSessionInfo) Logger() zerolog.Logger { return s.log }
+
+// Partial is true when the session only lists some resource groups of the subscription
+func (s *azureSessionInfo) Partial() bool { return s.partial }
+
+// clusterListing is the listing of a subscription's clusters, made once and shared by the sessions of each location
+type clusterListing struct {
+	once     sync.Once
+	clusters []*armcontainerservice.ManagedCluster
+	err      error
 }
 
+// getSubscriptions gets the subscriptions from the program arguments or subscription file, discovers them, or reads
+// them from the Azure CLI profile
 func (program *Options) getSubscriptions() <-chan string {
 	output := make(chan string)
 
-	if len(program.Subscriptions) < 1 {
+	send := func(subscriptions []string, err error, msg string) {
+		defer close(output)
+		if err != nil {
+			stats.Errors.Add(1)
+			log.Error().Err(err).Msg(msg)
+			return
+		}
+		for _, s := range subscriptions {
+			output <- s
+		}
+	}
+
+	switch {
+	case program.DiscoverSubscriptions:
+		go func() {
+			subscriptions, err := program.discoverSubscriptions()
+			send(subscriptions, err, "Failed to discover subscriptions")
+		}()
+
+	case len(program.Subscriptions) > 0:
+		go send(program.Subscriptions, nil, "")
+
+	case program.SubscriptionFile != "":
 		go func() {
 			defer close(output)
 			if f, err := os.Open(program.SubscriptionFile); err == nil {
@@ -47,97 +81,152 @@ func (program *Options) getSubscriptions() <-chan string {
 				log.Error().Str("file", program.SubscriptionFile).Err(err).Msg("Failed to open subscription file")
 			}
 		}()
-	} else {
+
+	default:
 		go func() {
-			defer close(output)
-			for _, s := range program.Subscriptions {
-				output <- s
-			}
+			subscriptions, err := program.profileSubscriptions()
+			send(subscriptions, err, "Failed to read subscriptions from the Azure CLI profile")
 		}()
 	}
 
 	return output
 }
 
-func (program *Options) getClustersFrom(s *azureSessionInfo, clusters chan<- AzureClusterInfo) {
+// Clusters gets the AKS clusters in the session's location, or in every location, from the subscription's listing
+func (program *Options) Clusters(sess core.Session, clusters chan<- core.Cluster) error {
+	s := sess.(*azureSessionInfo)
+
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
-	client := s.client
-	ctx := context.Background()
-	uniqueClusters := make(map[string]struct{})
+	found, err := program.list(s)
+	if err != nil {
+		stats.Errors.Add(1)
+		s.log.Error().Err(err).Msg("Error listing AKS clusters")
+		return err
+	}
 
-	pager := client.NewListPager(nil)
+	for _, c := range found {
+		if c.Name == nil || c.Location == nil {
+			continue
+		}
+		location := normalizeLocation(*c.Location)
+		if s.location != core.AllRegions && location != s.location {
+			continue
+		}
 
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			s.log.Error().Err(err).Msg("Error listing AKS clusters")
-			return
+		stats.Clusters.Add(1)
+		wg.Add(1)
+		go func(c *armcontainerservice.ManagedCluster) {
+			defer wg.Done()
+
+			s.log.Debug().Str("cluster_name", *c.Name).Str("cluster_location", location).Msg("Found AKS cluster")
+
+			clusters <- core.Cluster{
+				Provider: program.Name(),
+				Account:  s.subscription,
+				Region:   location,
+				Name:     *c.Name,
+				ID:       clusterID(c),
+				Tags:     tags(c),
+				Status:   provisioningState(c),
+				Session:  s,
+				Log:      s.log.With().Str("cluster_name", *c.Name).Str("location", location).Logger(),
+				Detail:   c,
+			}
+		}(c)
+	}
+
+	return nil
+}
+
+// list lists the clusters of the session's subscription, or of its resource groups if they are given, only once for
+// every session sharing the listing
+func (program *Options) list(s *azureSessionInfo) ([]*armcontainerservice.ManagedCluster, error) {
+	s.listing.once.Do(func() {
+		ctx := context.Background()
+		seen := make(map[string]bool)
+
+		add := func(page []*armcontainerservice.ManagedCluster) {
+			for _, c := range page {
+				id := clusterID(c)
+				if id != "" && seen[id] {
+					continue
+				}
+				if id != "" {
+					seen[id] = true
+				}
+				s.listing.clusters = append(s.listing.clusters, c)
+			}
 		}
 
-		for _, c := range page.Value {
-			clusterKey := fmt.Sprintf("%s-%s", *c.Name, *c.Location)
-			if _, exists := uniqueClusters[clusterKey]; !exists {
-				uniqueClusters[clusterKey] = struct{}{}
-				stats.Clusters.Add(1)
-
-				wg.Add(1)
-				go func(c *armcontainerservice.ManagedCluster) {
-					defer wg.Done()
-
-					s.log.Debug().
-						Str("cluster_name", *c.Name).
-						Str("location", *c.Location).
-						Msg("Found unique AKS cluster")
-
-					clusters <- AzureClusterInfo{
-						ManagedCluster: c,
-						log:            s.log.With().Str("cluster_name", *c.Name).Str("location", *c.Location).Logger(),
-						session:        s,
-					}
-				}(c)
+		if len(program.ResourceGroups) < 1 {
+			s.log.Debug().Msg("Requesting cluster listing for the subscription")
+			pager := s.client.NewListPager(nil)
+			for pager.More() {
+				page, err := pager.NextPage(ctx)
+				if err != nil {
+					s.listing.err = err
+					return
+				}
+				add(page.Value)
 			}
 		}
-	}
+
+		for _, group := range program.ResourceGroups {
+			s.log.Debug().Str("resource_group", group).Msg("Requesting cluster listing for the resource group")
+			pager := s.client.NewListByResourceGroupPager(group, nil)
+			for pager.More() {
+				page, err := pager.NextPage(ctx)
+				if err != nil {
+					s.listing.err = errors.Wrapf(err, "Failed to list resource group %s", group)
+					return
+				}
+				add(page.Value)
+			}
+		}
+
+		s.log.Debug().Int("number_of_clusters", len(s.listing.clusters)).Msg("AKS clusters found")
+	})
+
+	return s.listing.clusters, s.listing.err
+}
+
+// normalizeLocation turns a location display name such as "East US" into its name, "eastus"
+func normalizeLocation(location string) string {
+	return strings.ToLower(strings.ReplaceAll(location, " ", ""))
 }
 
-func (program *Options) getUniqueAzureSessions() <-chan *azureSessionInfo {
-	sessions := make(chan *azureSessionInfo)
+// Expand returns the subscription's session, which lists every location at once, or a session for each of the
+// locations to check if they are given.  The sessions share the subscription's listing.  Locations are normalized,
+// so that "East US" is scanned once as eastus, the location clusters are recorded in for pruning.
+func (program *Options) Expand(sess core.Session) <-chan core.Session {
+	info := sess.(*azureSessionInfo)
+	sessions := make(chan core.Session)
 
 	go func() {
-		wg := sync.WaitGroup{}
 		defer close(sessions)
-		defer wg.Wait()
-
-		subscriptions := make(map[string]bool)
-		for info := range program.getSubscriptionSessions() {
-			if _, found := subscriptions[info.subscription]; found {
-				info.log.Debug().Msg("Subscription is duplicate")
-				continue
-			}
 
-			stats.UniqueSubscriptions.Add(1)
-			info.log.Debug().Msg("Subscription is good for use")
-			subscriptions[info.subscription] = true
+		if len(program.Locations) < 1 {
 			sessions <- info
+			return
+		}
 
-			for _, location := range program.Locations {
-				if location != info.location {
-					stats.Locations.Add(1)
-					wg.Add(1)
-					go func(subscription, location string) {
-						defer wg.Done()
-						log := log.With().Str("subscription", subscription).Str("location", location).Logger()
-						log.Debug().Msg("Creating regional session")
-
-						if s, err := program.newAzureSession(subscription, location); err == nil {
-							sessions <- s
-						} else {
-							log.Error().Err(err).Msg("Failed to create Azure session")
-						}
-					}(info.subscription, location)
-				}
+		seen := make(map[string]bool)
+		for _, location := range program.Locations {
+			location = normalizeLocation(location)
+			if seen[location] {
+				continue
+			}
+			seen[location] = true
+
+			sessions <- &azureSessionInfo{
+				subscription: info.subscription,
+				location:     location,
+				client:       info.client,
+				listing:      info.listing,
+				partial:      info.partial,
+				log:          info.log.With().Str("location", location).Logger(),
 			}
 		}
 	}()
@@ -145,8 +234,9 @@ func (program *Options) getUniqueAzureSessions() <-chan *azureSessionInfo {
 	return sessions
 }
 
-func (program *Options) getSubscriptionSessions() <-chan *azureSessionInfo {
-	sessions := make(chan *azureSessionInfo)
+// Accounts gets a session for each subscription
+func (program *Options) Accounts() <-chan core.Session {
+	sessions := make(chan core.Session)
 	wg := sync.WaitGroup{}
 
 	go func() {
@@ -156,13 +246,13 @@ func (program *Options) getSubscriptionSessions() <-chan *azureSessionInfo {
 		subscriptions := program.getSubscriptions()
 
 		for s := range subscriptions {
-			stats.Subscriptions.Add(1)
-			log := log.With().Str("subscription", s).Str("location", program.Locations[0]).Logger()
+			stats.Accounts.Add(1)
+			log := log.With().Str("subscription", s).Logger()
 			wg.Add(1)
 			go func(s string) {
 				defer wg.Done()
-				if session, err := NewAzureSession(s, program.Locations[0], log); err == nil {
-					stats.UsableSubscriptions.Add(1)
+				if session, err := program.NewAzureSession(s, log); err == nil {
+					stats.UsableAccounts.Add(1)
 					sessions <- session
 				}
 			}(s)
@@ -172,40 +262,20 @@ func (program *Options) getSubscriptionSessions() <-chan *azureSessionInfo {
 	return sessions
 }
 
-func (program *Options) newAzureSession(subscription, location string) (*azureSessionInfo, error) {
+func (program *Options) NewAzureSession(subscription string, log zerolog.Logger) (*azureSessionInfo, error) {
 	cred, err := program.getAzureCredential()
 	if err != nil {
+		log.Error().Err(err).Msg("Failed to create Azure credential")
 		return nil, err
 	}
 
-	client, err := armcontainerservice.NewManagedClustersClient(subscription, cred, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	logger := log.With().Str("subscription", subscription).Str("location", location).Logger()
-	logger.Debug().Msg("Azure subscription session created")
-
-	return &azureSessionInfo{
-		subscription: subscription,
-		location:     location,
-		client:       client,
-		log:          logger,
-	}, nil
-}
-
-func (program *Options) getAzureCredential() (*azidentity.DefaultAzureCredential, error) {
-	return azidentity.NewDefaultAzureCredential(nil)
-}
-
-func NewAzureSession(subscription, location string, log zerolog.Logger) (*azureSessionInfo, error) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	options, err := program.armOptions()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create Azure credential")
+		log.Error().Err(err).Msg("Failed to configure Azure cloud")
 		return nil, err
 	}
 
-	client, err := armcontainerservice.NewManagedClustersClient(subscription, cred, nil)
+	client, err := armcontainerservice.NewManagedClustersClient(subscription, cred, options)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create Azure client factory")
 		return nil, err
@@ -215,8 +285,10 @@ func NewAzureSession(subscription, location string, log zerolog.Logger) (*azureS
 
 	return &azureSessionInfo{
 		subscription: subscription,
-		location:     location,
+		location:     core.AllRegions,
 		client:       client,
+		listing:      &clusterListing{},
+		partial:      len(program.ResourceGroups) > 0,
 		log:          log,
 	}, nil
 }
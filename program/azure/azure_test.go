@@ -0,0 +1,51 @@
+package azure
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/rs/zerolog/log"
+)
+
+func TestLocations(t *testing.T) {
+	program := &Options{Locations: []string{"East US", "eastus", "West Europe"}}
+
+	// A listing made already, as if by another session of the subscription
+	listing := &clusterListing{}
+	listing.once.Do(func() {
+		for _, c := range []struct{ name, location string }{{"a", "eastus"}, {"b", "East US"}, {"c", "westeurope"}, {"d", "eastus2"}} {
+			listing.clusters = append(listing.clusters, &armcontainerservice.ManagedCluster{
+				ID:       to.Ptr("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/" + c.name),
+				Name:     to.Ptr(c.name),
+				Location: to.Ptr(c.location),
+			})
+		}
+	})
+
+	var regions, found []string
+	for s := range program.Expand(&azureSessionInfo{subscription: "sub", listing: listing, log: log.Logger}) {
+		regions = append(regions, s.Region())
+
+		clusters := make(chan core.Cluster, 10)
+		if err := program.Clusters(s, clusters); err != nil {
+			t.Fatal(err)
+		}
+		close(clusters)
+		for c := range clusters {
+			if c.Region != s.Region() {
+				t.Errorf("cluster %s is in region %s, found by the session for %s", c.Name, c.Region, s.Region())
+			}
+			found = append(found, c.Name)
+		}
+	}
+
+	if got := strings.Join(regions, ","); got != "eastus,westeurope" {
+		t.Errorf("got sessions for %s", got)
+	}
+	if len(found) != 3 {
+		t.Errorf("found clusters %v, want a, b and c", found)
+	}
+}
@@ -0,0 +1,168 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// azureCloud is an Azure cloud, with the names kubelogin and the Azure CLI profile give it
+type azureCloud struct {
+	config             cloud.Configuration
+	environment        string
+	profileEnvironment string
+
+	// environmentFile describes a custom cloud to kubelogin, through AZURE_ENVIRONMENT_FILEPATH
+	environmentFile string
+}
+
+var clouds = map[string]azureCloud{
+	"public": {config: cloud.AzurePublic, environment: "AzurePublicCloud", profileEnvironment: "AzureCloud"},
+	"usgov":  {config: cloud.AzureGovernment, environment: "AzureUSGovernmentCloud", profileEnvironment: "AzureUSGovernment"},
+	"china":  {config: cloud.AzureChina, environment: "AzureChinaCloud", profileEnvironment: "AzureChinaCloud"},
+}
+
+// armMetadata is the part of an ARM metadata endpoint's response describing how to authenticate to it
+type armMetadata struct {
+	GalleryEndpoint string `json:"galleryEndpoint"`
+	GraphEndpoint   string `json:"graphEndpoint"`
+	PortalEndpoint  string `json:"portalEndpoint"`
+	Authentication  struct {
+		LoginEndpoint string   `json:"loginEndpoint"`
+		Audiences     []string `json:"audiences"`
+	} `json:"authentication"`
+}
+
+// stackEnvironment is the environment file kubelogin reads for its AzureStackCloud environment
+type stackEnvironment struct {
+	Name                      string `json:"name"`
+	ManagementPortalURL       string `json:"managementPortalURL"`
+	ResourceManagerEndpoint   string `json:"resourceManagerEndpoint"`
+	ActiveDirectoryEndpoint   string `json:"activeDirectoryEndpoint"`
+	GalleryEndpoint           string `json:"galleryEndpoint"`
+	GraphEndpoint             string `json:"graphEndpoint"`
+	ServiceManagementEndpoint string `json:"serviceManagementEndpoint"`
+	TokenAudience             string `json:"tokenAudience"`
+	ResourceIdentifiers       struct {
+		Graph string `json:"graph"`
+	} `json:"resourceIdentifiers"`
+}
+
+// cloud gets the Azure cloud to use, reading the metadata of a custom cloud's ARM endpoint only once
+func (program *Options) cloud() (azureCloud, error) {
+	program.cloudOnce.Do(func() {
+		if program.Cloud != "custom" {
+			program.azureCloud = clouds[program.Cloud]
+			return
+		}
+
+		var metadata *armMetadata
+		program.azureCloud, metadata, program.cloudErr = customCloud(program.ARMEndpoint)
+		if program.cloudErr == nil {
+			program.cloudErr = program.writeEnvironment(metadata)
+		}
+	})
+	return program.azureCloud, program.cloudErr
+}
+
+// customCloud reads the configuration of a cloud such as Azure Stack Hub from the metadata of its ARM endpoint
+func customCloud(endpoint string) (azureCloud, *armMetadata, error) {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/metadata/endpoints?api-version=2015-01-01", nil)
+	if err != nil {
+		return azureCloud{}, nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return azureCloud{}, nil, errors.Wrapf(err, "Failed to read the metadata of %s", endpoint)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return azureCloud{}, nil, errors.Errorf("Failed to read the metadata of %s: %s", endpoint, resp.Status)
+	}
+
+	var metadata armMetadata
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return azureCloud{}, nil, errors.Wrapf(err, "Failed to read the metadata of %s", endpoint)
+	}
+	if metadata.Authentication.LoginEndpoint == "" || len(metadata.Authentication.Audiences) < 1 {
+		return azureCloud{}, nil, errors.Errorf("Metadata of %s has no login endpoint or audience", endpoint)
+	}
+
+	return azureCloud{
+		config: cloud.Configuration{
+			ActiveDirectoryAuthorityHost: metadata.Authentication.LoginEndpoint,
+			Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {
+					Audience: metadata.Authentication.Audiences[0],
+					Endpoint: endpoint,
+				},
+			},
+		},
+		environment: "AzureStackCloud",
+	}, &metadata, nil
+}
+
+// writeEnvironment writes the custom cloud's environment file for kubelogin, so the kubeconfig can point it there
+func (program *Options) writeEnvironment(metadata *armMetadata) error {
+	audience := metadata.Authentication.Audiences[0]
+	env := stackEnvironment{
+		Name:                      "AzureStackCloud",
+		ManagementPortalURL:       metadata.PortalEndpoint,
+		ResourceManagerEndpoint:   strings.TrimSuffix(program.ARMEndpoint, "/") + "/",
+		ActiveDirectoryEndpoint:   metadata.Authentication.LoginEndpoint,
+		GalleryEndpoint:           metadata.GalleryEndpoint,
+		GraphEndpoint:             metadata.GraphEndpoint,
+		ServiceManagementEndpoint: audience,
+		TokenAudience:             audience,
+	}
+	env.ResourceIdentifiers.Graph = metadata.GraphEndpoint
+
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(program.EnvironmentFile), 0700); err != nil {
+		return err
+	}
+	if err := core.WriteFileAtomic(program.EnvironmentFile, data); err != nil {
+		return errors.Wrap(err, "Failed to write the custom cloud's environment file")
+	}
+
+	program.azureCloud.environmentFile = program.EnvironmentFile
+	return nil
+}
+
+// clientOptions are the options for the credential and clients, which point them at the cloud
+func (program *Options) clientOptions() (azcore.ClientOptions, error) {
+	c, err := program.cloud()
+	if err != nil {
+		return azcore.ClientOptions{}, err
+	}
+	return azcore.ClientOptions{Cloud: c.config}, nil
+}
+
+// armOptions are the options for the ARM clients
+func (program *Options) armOptions() (*arm.ClientOptions, error) {
+	options, err := program.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	return &arm.ClientOptions{ClientOptions: options}, nil
+}
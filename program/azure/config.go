@@ -1,92 +1,243 @@
 package azure
 
 import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice"
+	"github.com/clouddrove/kuconf/program/core"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
-	"os"
 )
 
-func captureConfig(c AzureClusterInfo, resourceGroup string, i *api.Config) error {
-	certificateData := []byte(*c.ManagedCluster.Properties.NetworkProfile.ServiceCidr)
+// aksServerID is the server application ID of AKS clusters with AKS-managed Azure AD integration
+const aksServerID = "6dae42f8-4368-4678-94ff-3960e28e3630"
+
+// kubeloginClientID is the client application kubelogin signs in with for the interactive login modes
+const kubeloginClientID = "80faf920-1908-4b52-b5ef-a8e7bedfc67a"
+
+// Render creates the kubeconfig entry for an AKS cluster.  The server and certificate authority come from the
+// cluster's user credentials.  Clusters with Azure AD integration get a kubelogin exec; the local account
+// credentials are only used when --local-account asks for them.
+func (program *Options) Render(c core.Cluster) (*core.Entry, error) {
+	info := c.Detail.(*armcontainerservice.ManagedCluster)
+	s := c.Session.(*azureSessionInfo)
+
+	aad := info.Properties != nil && info.Properties.AADProfile != nil
+	localAccounts := info.Properties == nil || info.Properties.DisableLocalAccounts == nil || !*info.Properties.DisableLocalAccounts
 
-	cluster := api.Cluster{
-		Server:                   "https://" + *c.ManagedCluster.Properties.Fqdn,
-		CertificateAuthorityData: certificateData,
+	useLocal := false
+	switch {
+	case program.LocalAccount == "admin" && localAccounts:
+		useLocal = true
+	case program.LocalAccount == "user" && !aad:
+		useLocal = true
+	case !aad:
+		return nil, &core.SkipError{Reason: "Cluster has no Azure AD integration, use --local-account to use its local accounts"}
 	}
 
-	user := api.AuthInfo{
-		Exec: &api.ExecConfig{
-			APIVersion: "client.authentication.k8s.io/v1beta1",
-			Command:    "azure-cli",
-			Args: []string{
-				"aks", "get-credentials",
-				"--resource-group", resourceGroup,
-				"--name", *c.ManagedCluster.Name,
-			},
-		},
+	credentials, err := program.credentials(s, info, useLocal)
+	if err != nil {
+		c.Log.Error().Err(err).Msg("Failed to get cluster credentials from Azure")
+		return nil, err
 	}
 
-	context := api.Context{
-		Cluster:  *c.ManagedCluster.Name,
-		AuthInfo: *c.ManagedCluster.Name,
+	cluster, user, err := firstEntry(credentials)
+	if err != nil {
+		return nil, err
 	}
 
-	i.Clusters[*c.ManagedCluster.Name] = &cluster
-	i.AuthInfos[*c.ManagedCluster.Name] = &user
-	i.Contexts[*c.ManagedCluster.Name] = &context
+	if !useLocal {
+		exec, err := program.kubelogin(info.Properties.AADProfile, user.Exec)
+		if err != nil {
+			return nil, err
+		}
+		user = &api.AuthInfo{Exec: exec}
+	}
 
-	return nil
+	return &core.Entry{
+		Name:         *info.Name,
+		ClusterName:  *info.Name,
+		AuthInfoName: *info.Name,
+		Cluster: &api.Cluster{
+			Server:                   cluster.Server,
+			CertificateAuthorityData: cluster.CertificateAuthorityData,
+		},
+		AuthInfo:   user,
+		ManagedEnv: []string{"AZURE_ENVIRONMENT_FILEPATH"},
+	}, nil
 }
 
-func (program *Options) ReadConfig() (*api.Config, error) {
-	if _, err := os.Stat(program.KubeConfig); os.IsNotExist(err) {
-		c := api.NewConfig()
-		return c, nil
+// credentials gets the kubeconfig Azure generates for the cluster: the admin one when the admin local account is
+// wanted, otherwise the user one
+func (program *Options) credentials(s *azureSessionInfo, info *armcontainerservice.ManagedCluster, local bool) ([]byte, error) {
+	ctx := context.Background()
+	group := resourceGroup(info)
+
+	var results []*armcontainerservice.CredentialResult
+	if local && program.LocalAccount == "admin" {
+		out, err := s.client.ListClusterAdminCredentials(ctx, group, *info.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+		results = out.Kubeconfigs
 	} else {
-		c, err := clientcmd.LoadFromFile(program.KubeConfig)
+		options := &armcontainerservice.ManagedClustersClientListClusterUserCredentialsOptions{}
+		if !local {
+			options.Format = to.Ptr(armcontainerservice.FormatExec)
+		}
+		out, err := s.client.ListClusterUserCredentials(ctx, group, *info.Name, options)
 		if err != nil {
 			return nil, err
 		}
-		return c, nil
+		results = out.Kubeconfigs
+	}
+
+	if len(results) < 1 || len(results[0].Value) == 0 {
+		return nil, errors.New("Azure returned no credentials for the cluster")
 	}
+	return results[0].Value, nil
 }
 
-func (program *Options) WriteConfig(config *api.Config) error {
-	newFile := program.KubeConfig + ".tmp"
-	bakFile := program.KubeConfig + ".bak"
+// firstEntry gets the cluster and user of the current context of a kubeconfig
+func firstEntry(data []byte) (*api.Cluster, *api.AuthInfo, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to read the kubeconfig from Azure")
+	}
 
-	err := clientcmd.WriteToFile(*config, newFile)
-	log := log.With().Str("kubeconfig_file", program.KubeConfig).Logger()
+	current, found := config.Contexts[config.CurrentContext]
+	if !found {
+		return nil, nil, errors.New("Kubeconfig from Azure has no current context")
+	}
 
+	cluster, user := config.Clusters[current.Cluster], config.AuthInfos[current.AuthInfo]
+	if cluster == nil || user == nil {
+		return nil, nil, errors.New("Kubeconfig from Azure has no cluster or user for its current context")
+	}
+
+	return cluster, user, nil
+}
+
+// kubelogin is the kubelogin exec for a cluster with Azure AD integration.  The server ID is taken from the exec
+// Azure generated for the cluster, which knows the one for its cloud.
+func (program *Options) kubelogin(aad *armcontainerservice.ManagedClusterAADProfile, generated *api.ExecConfig) (*api.ExecConfig, error) {
+	c, err := program.cloud()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if _, err := os.Stat(bakFile); err == nil {
-		err = os.RemoveAll(bakFile)
-		if err != nil {
-			return errors.Wrap(err, "Failed to remove config backup file")
+	serverID := execArg(generated, "--server-id")
+	if serverID == "" {
+		serverID = aksServerID
+		if (aad.Managed == nil || !*aad.Managed) && aad.ServerAppID != nil {
+			// Legacy Azure AD integration uses the cluster's own server application
+			serverID = *aad.ServerAppID
 		}
 	}
 
-	if _, err := os.Stat(program.KubeConfig); os.IsNotExist(err) {
-		log.Debug().Msg("No existing config file. Copying new to config")
-		return os.Rename(newFile, program.KubeConfig)
+	mode := program.loginMode()
+	args := []string{
+		"get-token",
+		"--login", mode,
+		"--server-id", serverID,
+		"--environment", c.environment,
 	}
 
-	if err := os.Rename(program.KubeConfig, bakFile); err == nil {
-		if e2 := os.Rename(newFile, program.KubeConfig); e2 == nil {
-			return nil
-		} else {
-			if restoreErr := os.Rename(bakFile, program.KubeConfig); restoreErr != nil {
-				return errors.Wrap(restoreErr, "Error restoring kubeconfig. Backup left in "+bakFile)
-			} else {
-				return errors.Wrap(e2, "Error saving new kubeconfig")
-			}
+	switch mode {
+	case "msi":
+		if program.ClientID != "" {
+			args = append(args, "--client-id", program.ClientID)
 		}
-	} else {
-		return err
+	case "spn":
+		if program.ClientID != "" {
+			args = append(args, "--client-id", program.ClientID)
+		}
+		if program.ClientCertificate != "" {
+			args = append(args, "--client-certificate", program.ClientCertificate)
+		}
+	case "devicecode", "interactive":
+		clientID := kubeloginClientID
+		if program.Auth == "device-code" && program.ClientID != "" {
+			clientID = program.ClientID
+		}
+		args = append(args, "--client-id", clientID)
+	}
+
+	switch mode {
+	case "devicecode", "interactive", "spn", "ropc", "workloadidentity":
+		if aad.TenantID != nil {
+			args = append(args, "--tenant-id", *aad.TenantID)
+		}
+	}
+
+	exec := &api.ExecConfig{
+		APIVersion: "client.authentication.k8s.io/v1beta1",
+		Command:    "kubelogin",
+		Args:       args,
+	}
+	if c.environmentFile != "" {
+		exec.Env = []api.ExecEnvVar{{Name: "AZURE_ENVIRONMENT_FILEPATH", Value: c.environmentFile}}
+	}
+
+	return exec, nil
+}
+
+// execArg gets the value of a flag of an exec command, given either as "--flag value" or "--flag=value"
+func execArg(exec *api.ExecConfig, flag string) string {
+	if exec == nil {
+		return ""
+	}
+	for i, arg := range exec.Args {
+		if arg == flag && i+1 < len(exec.Args) {
+			return exec.Args[i+1]
+		}
+		if value, found := strings.CutPrefix(arg, flag+"="); found {
+			return value
+		}
+	}
+	return ""
+}
+
+// tags returns the cluster's tags
+func tags(c *armcontainerservice.ManagedCluster) map[string]string {
+	out := make(map[string]string)
+	for k, v := range c.Tags {
+		if v != nil {
+			out[k] = *v
+		}
+	}
+	return out
+}
+
+// provisioningState is the provisioning state of the cluster, e.g. Succeeded
+func provisioningState(c *armcontainerservice.ManagedCluster) string {
+	if c.Properties == nil || c.Properties.ProvisioningState == nil {
+		return ""
+	}
+	return *c.Properties.ProvisioningState
+}
+
+// clusterID is the cluster's resource ID in lower case, since the case of its parts varies between API responses
+func clusterID(c *armcontainerservice.ManagedCluster) string {
+	if c.ID == nil {
+		return ""
+	}
+	return strings.ToLower(*c.ID)
+}
+
+// resourceGroup gets the resource group name from the cluster's resource ID
+func resourceGroup(c *armcontainerservice.ManagedCluster) string {
+	if c.ID == nil {
+		return ""
+	}
+
+	id, err := arm.ParseResourceID(*c.ID)
+	if err != nil {
+		return ""
 	}
+	return id.ResourceGroupName
 }
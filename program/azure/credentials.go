@@ -0,0 +1,116 @@
+package azure
+
+import (
+	"context"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"os"
+)
+
+// getAzureCredential gets the credential for the Azure clients, created once and shared by every subscription and
+// the subscription discovery.  A credential which can't be created, e.g. for an unreadable certificate, is a
+// mistake in the auth options, so it is counted in the statistics here rather than by everything that needed it.
+func (program *Options) getAzureCredential() (azcore.TokenCredential, error) {
+	program.credentialOnce.Do(func() {
+		program.credential, program.credentialErr = program.newCredential()
+		if program.credentialErr != nil {
+			stats.Errors.Add(1)
+			program.credentialErr = errors.Wrapf(program.credentialErr, "Failed to create %s Azure credential", program.Auth)
+		}
+	})
+	return program.credential, program.credentialErr
+}
+
+// newCredential creates the credential for the auth mode
+func (program *Options) newCredential() (azcore.TokenCredential, error) {
+	clientOptions, err := program.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Azure Stack Hub signs in with its own authority, which Azure AD instance discovery doesn't know
+	custom := program.Cloud == "custom"
+
+	switch program.Auth {
+	case "cli":
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: program.Tenant,
+		})
+
+	case "env":
+		return azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{
+			ClientOptions: clientOptions,
+		})
+
+	case "managed-identity":
+		options := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if program.ClientID != "" {
+			options.ID = azidentity.ClientID(program.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+
+	case "workload-identity":
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions:            clientOptions,
+			ClientID:                 program.ClientID,
+			TenantID:                 program.Tenant,
+			DisableInstanceDiscovery: custom,
+		})
+
+	case "device-code":
+		return azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{
+			ClientOptions:            clientOptions,
+			ClientID:                 program.ClientID,
+			TenantID:                 program.Tenant,
+			DisableInstanceDiscovery: custom,
+			UserPrompt: func(_ context.Context, message azidentity.DeviceCodeMessage) error {
+				// Stdout may be the output of the program, so the instructions are logged instead
+				log.Warn().Msg(message.Message)
+				return nil
+			},
+		})
+
+	case "service-principal-cert":
+		data, err := os.ReadFile(program.ClientCertificate)
+		if err != nil {
+			return nil, err
+		}
+		certs, key, err := azidentity.ParseCertificates(data, []byte(program.ClientCertificatePassword))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read client certificate %s", program.ClientCertificate)
+		}
+		return azidentity.NewClientCertificateCredential(program.Tenant, program.ClientID, certs, key, &azidentity.ClientCertificateCredentialOptions{
+			ClientOptions:            clientOptions,
+			DisableInstanceDiscovery: custom,
+		})
+
+	default:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			ClientOptions:            clientOptions,
+			TenantID:                 program.Tenant,
+			DisableInstanceDiscovery: custom,
+		})
+	}
+}
+
+// loginMode is the kubelogin login mode, the one matching the auth mode unless --login-mode is given
+func (program *Options) loginMode() string {
+	if program.LoginMode != "auto" {
+		return program.LoginMode
+	}
+
+	switch program.Auth {
+	case "env", "service-principal-cert":
+		return "spn"
+	case "managed-identity":
+		return "msi"
+	case "workload-identity":
+		return "workloadidentity"
+	case "device-code":
+		return "devicecode"
+	default:
+		return "azurecli"
+	}
+}
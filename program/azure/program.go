@@ -1,151 +1,75 @@
 package azure
 
 import (
-	"fmt"
-	"io"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/pkg/errors"
 	"os"
-	"runtime"
-	"strings"
 	"sync"
-
-	"github.com/alecthomas/kong"
-	"github.com/mattn/go-colorable"
-	"github.com/pkg/errors"
-	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 )
 
+// Options is the structure of the Azure command options
 type Options struct {
-	Version bool `help:"Show program version"`
-
-	KubeConfig       string   `group:"Input" short:"k" help:"Kubeconfig file" type:"path" default:"~/.kube/config"`
 	Subscriptions    []string `group:"Input" help:"List of Azure subscriptions to check"`
 	SubscriptionFile string   `group:"Input" help:"File containing list of Azure subscriptions" type:"path"`
-	Locations        []string `group:"Input" help:"List of Azure locations to check" env:"AZURE_LOCATIONS" default:"eastus,westus,centralus,northeurope,westeurope"`
-	ResourceGroups   []string `group:"Input" help:"List of Azure resource groups to check"`
-
-	Debug        bool   `group:"Info" help:"Show debugging information"`
-	OutputFormat string `group:"Info" enum:"auto,jsonl,terminal" default:"auto" help:"How to show program output (auto|terminal|jsonl)"`
-	Quiet        bool   `group:"Info" help:"Be less verbose than usual"`
+	Locations        []string `group:"Input" help:"Only check these Azure locations.  Checks every location if none are given" env:"AZURE_LOCATIONS"`
+	ResourceGroups   []string `group:"Input" help:"Only check these Azure resource groups.  Checks the whole subscription if none are given"`
+	AzureProfile     string   `group:"Input" help:"Azure CLI profile listing the subscriptions of 'az login', used when no subscriptions are given" type:"path" default:"~/.azure/azureProfile.json"`
+
+	DiscoverSubscriptions bool   `group:"Subscriptions" help:"Check every enabled subscription visible to the credentials"`
+	Tenant                string `group:"Subscriptions" help:"Only use subscriptions in this tenant, and authenticate to it"`
+	ManagementGroup       string `group:"Subscriptions" help:"Only discover subscriptions below this management group"`
+
+	Auth                      string `group:"Authentication" help:"How kuconf authenticates to Azure (${enum})" enum:"default,cli,env,managed-identity,workload-identity,device-code,service-principal-cert" default:"default"`
+	ClientID                  string `group:"Authentication" help:"Client ID of the user-assigned managed identity, workload identity, device code application or service principal"`
+	ClientCertificate         string `group:"Authentication" help:"Certificate file of the service principal, PEM or PKCS#12, for --auth=service-principal-cert" type:"path" env:"AZURE_CLIENT_CERTIFICATE_PATH"`
+	ClientCertificatePassword string `group:"Authentication" help:"Password of the service principal's certificate file" env:"AZURE_CLIENT_CERTIFICATE_PASSWORD"`
+	LoginMode                 string `group:"Authentication" help:"kubelogin login mode for clusters with Azure AD integration, 'auto' to match --auth (${enum})" enum:"auto,azurecli,devicecode,interactive,spn,ropc,msi,workloadidentity,azd" default:"auto"`
+	LocalAccount              string `group:"Authentication" help:"Use local account credentials: 'user' for clusters without Azure AD integration, 'admin' for every cluster with local accounts enabled (${enum})" enum:"none,user,admin" default:"none"`
+
+	Cloud           string `group:"Cloud" help:"Azure cloud to use, 'custom' for Azure Stack Hub (${enum})" enum:"public,usgov,china,custom" default:"public"`
+	ARMEndpoint     string `group:"Cloud" name:"arm-endpoint" help:"ARM endpoint of the custom cloud, whose metadata gives its login endpoint, e.g. https://management.local.azurestack.external"`
+	EnvironmentFile string `group:"Cloud" help:"File the custom cloud's environment is written to, which kubelogin reads through AZURE_ENVIRONMENT_FILEPATH" type:"path" default:"${azure_environment_file}"`
+
+	cloudOnce  sync.Once  `kong:"-"`
+	azureCloud azureCloud `kong:"-"`
+	cloudErr   error      `kong:"-"`
+
+	credentialOnce sync.Once              `kong:"-"`
+	credential     azcore.TokenCredential `kong:"-"`
+	credentialErr  error                  `kong:"-"`
 }
 
-func (program *Options) Parse(args []string) (*kong.Context, error) {
-	parser, err := kong.New(program,
-		kong.ShortUsageOnError(),
-		kong.Description("Download kubeconfigs in bulk by examining AKS clusters across multiple subscriptions and locations"),
-	)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	return parser.Parse(args)
+// Name is the name of the provider
+func (program *Options) Name() string {
+	return "azure"
 }
 
-func (program *Options) Run(options *Options) error {
-	config, err := program.ReadConfig()
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to read kubeconfig file")
-		return err
-	}
-
-	clusters := make(chan AzureClusterInfo)
-	wg := sync.WaitGroup{}
-
-	for sess := range program.getUniqueAzureSessions() {
-		wg.Add(1)
-		go func(sess *azureSessionInfo) {
-			defer wg.Done()
-			program.getClustersFrom(sess, clusters)
-		}(sess)
-	}
-
-	go func() {
-		wg.Wait()
-		close(clusters)
-	}()
-
-	for c := range clusters {
-		resourceGroup := ""
-		if id := *c.ManagedCluster.ID; id != "" {
-			parts := strings.Split(id, "/")
-			for i, part := range parts {
-				if part == "resourceGroups" && i+1 < len(parts) {
-					resourceGroup = parts[i+1]
-					break
-				}
-			}
-		}
-
-		if err := captureConfig(c, resourceGroup, config); err != nil {
-			stats.Errors.Add(1)
-			log.Error().Err(err).Msg("Error capturing cluster configuration")
-		}
-	}
-
-	if err := program.WriteConfig(config); err != nil {
-		stats.Errors.Add(1)
-		log.Error().
-			Err(err).
-			Str("file", program.KubeConfig).
-			Msg("Error saving kubeconfig")
-	}
-
-	stats.Log()
-	if stats.Errors.Load() > 0 {
-		return errors.New("Errors encountered during run")
-	}
-	return nil
+// Stats returns the statistics for the provider
+func (program *Options) Stats() *core.Stats {
+	return stats
 }
 
-func (program *Options) AfterApply() error {
-	program.initLogging()
-	if len(program.Locations) < 1 {
-		return errors.New("Must specify at least one location")
-	}
-	if len(program.Subscriptions) < 1 && program.SubscriptionFile == "" {
-		return errors.New("Must specify either subscriptions or subscription file")
+// Run runs the program
+func (program *Options) Run(options *core.Options) error {
+	if err := program.Check(); err != nil {
+		return err
 	}
-	return nil
+	return options.Sync(program)
 }
 
-func (program *Options) initLogging() {
-	if program.Version {
-		fmt.Println(Version)
-		os.Exit(0)
+// Check returns an error if there is not enough configuration to run
+func (program *Options) Check() error {
+	if program.Cloud == "custom" && program.ARMEndpoint == "" {
+		return errors.New("--cloud=custom needs --arm-endpoint")
 	}
-
-	switch {
-	case program.Debug:
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case program.Quiet:
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
-	default:
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if program.Auth == "service-principal-cert" && (program.Tenant == "" || program.ClientID == "" || program.ClientCertificate == "") {
+		return errors.New("--auth=service-principal-cert needs --tenant, --client-id and --client-certificate")
 	}
-
-	var out io.Writer = os.Stdout
-	if os.Getenv("TERM") == "" && runtime.GOOS == "windows" {
-		out = colorable.NewColorableStdout()
-	}
-
-	if program.OutputFormat == "terminal" ||
-		(program.OutputFormat == "auto" && isTerminal(os.Stdout)) {
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: out})
-	} else {
-		log.Logger = log.Output(out)
-	}
-
-	log.Logger.Debug().
-		Str("version", Version).
-		Str("program", os.Args[0]).
-		Msg("Starting")
-}
-
-func isTerminal(file *os.File) bool {
-	if fileInfo, err := file.Stat(); err != nil {
-		log.Err(err).Msg("Error running stat")
-		return false
-	} else {
-		return (fileInfo.Mode() & os.ModeCharDevice) != 0
+	if len(program.Subscriptions) < 1 && program.SubscriptionFile == "" && !program.DiscoverSubscriptions {
+		if _, err := os.Stat(program.AzureProfile); err != nil {
+			return errors.New("Must specify subscriptions, a subscription file or --discover-subscriptions, or log in with 'az login'")
+		}
 	}
+	return nil
 }
@@ -0,0 +1,156 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/managementgroups/armmanagementgroups"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armsubscriptions"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// azureProfile is the part of the Azure CLI's azureProfile.json listing the subscriptions "az login" found
+type azureProfile struct {
+	Subscriptions []struct {
+		ID              string `json:"id"`
+		Name            string `json:"name"`
+		State           string `json:"state"`
+		TenantID        string `json:"tenantId"`
+		EnvironmentName string `json:"environmentName"`
+	} `json:"subscriptions"`
+}
+
+// profileSubscriptions reads the enabled subscriptions of the cloud and the tenant, or of every tenant, from the Azure
+// CLI profile
+func (program *Options) profileSubscriptions() ([]string, error) {
+	c, err := program.cloud()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(program.AzureProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	// The Azure CLI writes the file with a byte order mark
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var profile azureProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %s", program.AzureProfile)
+	}
+
+	var subscriptions []string
+	for _, s := range profile.Subscriptions {
+		if s.State != string(armsubscriptions.SubscriptionStateEnabled) {
+			log.Debug().Str("subscription", s.ID).Str("state", s.State).Msg("Skipping subscription which isn't enabled")
+			continue
+		}
+		if program.Tenant != "" && !strings.EqualFold(s.TenantID, program.Tenant) {
+			continue
+		}
+		if c.profileEnvironment != "" && s.EnvironmentName != "" && s.EnvironmentName != c.profileEnvironment {
+			log.Debug().Str("subscription", s.ID).Str("environment", s.EnvironmentName).Msg("Skipping subscription in another cloud")
+			continue
+		}
+		subscriptions = append(subscriptions, s.ID)
+	}
+
+	return subscriptions, nil
+}
+
+// discoverSubscriptions lists the enabled subscriptions visible to the credential, only those below the management
+// group if one is given
+func (program *Options) discoverSubscriptions() ([]string, error) {
+	ctx := context.Background()
+
+	cred, err := program.getAzureCredential()
+	if err != nil {
+		return nil, err
+	}
+
+	var inGroup map[string]bool
+	if program.ManagementGroup != "" {
+		if inGroup, err = program.groupSubscriptions(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	options, err := program.armOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := armsubscriptions.NewClient(cred, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []string
+	pager := client.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to list subscriptions")
+		}
+
+		for _, s := range page.Value {
+			if s.SubscriptionID == nil {
+				continue
+			}
+			id := *s.SubscriptionID
+			switch {
+			case s.State == nil || *s.State != armsubscriptions.SubscriptionStateEnabled:
+				log.Debug().Str("subscription", id).Msg("Skipping subscription which isn't enabled")
+			case program.Tenant != "" && (s.TenantID == nil || !strings.EqualFold(*s.TenantID, program.Tenant)):
+				log.Debug().Str("subscription", id).Msg("Skipping subscription in another tenant")
+			case inGroup != nil && !inGroup[strings.ToLower(id)]:
+				log.Debug().Str("subscription", id).Msg("Skipping subscription outside the management group")
+			default:
+				subscriptions = append(subscriptions, id)
+			}
+		}
+	}
+
+	sort.Strings(subscriptions)
+	return subscriptions, nil
+}
+
+// groupSubscriptions gets the IDs of every subscription below the management group, in lower case
+func (program *Options) groupSubscriptions(ctx context.Context) (map[string]bool, error) {
+	cred, err := program.getAzureCredential()
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := program.armOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := armmanagementgroups.NewClient(cred, options)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := make(map[string]bool)
+	pager := client.NewGetDescendantsPager(program.ManagementGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to list management group %s", program.ManagementGroup)
+		}
+		for _, d := range page.Value {
+			if d.Type != nil && d.Name != nil && strings.EqualFold(*d.Type, "/subscriptions") {
+				subscriptions[strings.ToLower(*d.Name)] = true
+			}
+		}
+	}
+
+	return subscriptions, nil
+}
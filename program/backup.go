@@ -0,0 +1,27 @@
+package program
+
+import (
+	"github.com/clouddrove/kuconf/program/core"
+	"os"
+)
+
+// BackupsCmd manages the kubeconfig backups
+type BackupsCmd struct {
+	List BackupsListCmd `cmd:"" help:"List the kubeconfig backups and what each contains"`
+}
+
+// BackupsListCmd lists the kubeconfig backups
+type BackupsListCmd struct{}
+
+func (cmd *BackupsListCmd) Run(options *core.Options) error {
+	return options.ListBackups(os.Stdout)
+}
+
+// RestoreCmd restores the kubeconfig from a backup
+type RestoreCmd struct {
+	At string `help:"Timestamp of the backup to restore, as shown by 'backups list'.  A prefix picks the newest match.  Restores the newest backup if not given"`
+}
+
+func (cmd *RestoreCmd) Run(options *core.Options) error {
+	return options.Restore(cmd.At)
+}
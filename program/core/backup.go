@@ -0,0 +1,215 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// backupTimeFormat is the timestamp in backup file names.  It sorts in time order and is safe in file names.
+const backupTimeFormat = "20060102T150405.000Z"
+
+// Backup is a saved copy of the kubeconfig
+type Backup struct {
+	Path  string
+	Stamp string
+	Time  time.Time
+}
+
+// backupPrefix is the start of the file name of every backup of the kubeconfig
+func (program *Options) backupPrefix() string {
+	return filepath.Base(program.KubeConfig) + "."
+}
+
+// backup saves data as a new backup of the kubeconfig and removes the oldest backups beyond the retention count.
+// Data identical to the newest backup isn't saved again, since it would only push an older backup out.
+func (program *Options) backup(data []byte) error {
+	backups, err := program.Backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) > 0 {
+		newest := backups[len(backups)-1].Path
+		if previous, err := os.ReadFile(newest); err == nil && bytes.Equal(previous, data) {
+			log.Debug().Str("backup", newest).Msg("Kubeconfig is unchanged since the last backup")
+			return nil
+		}
+	}
+
+	stamp := time.Now().UTC().Format(backupTimeFormat)
+	path := filepath.Join(program.BackupDir, program.backupPrefix()+stamp+".yaml")
+
+	if err := WriteFileAtomic(path, data); err != nil {
+		return err
+	}
+	log.Debug().Str("backup", path).Msg("Saved kubeconfig backup")
+
+	backups, err = program.Backups()
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > program.BackupCount && program.BackupCount > 0 {
+		log.Debug().Str("backup", backups[0].Path).Msg("Removing old kubeconfig backup")
+		if err := os.Remove(backups[0].Path); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// Backups returns the backups of the kubeconfig, oldest first
+func (program *Options) Backups() ([]Backup, error) {
+	prefix := program.backupPrefix()
+
+	entries, err := os.ReadDir(program.BackupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".yaml") {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".yaml")
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, Backup{
+			Path:  filepath.Join(program.BackupDir, name),
+			Stamp: stamp,
+			Time:  t,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.Before(backups[j].Time)
+	})
+
+	return backups, nil
+}
+
+// findBackup returns the newest backup whose timestamp starts with at, or the newest backup if at is empty
+func (program *Options) findBackup(at string) (*Backup, error) {
+	backups, err := program.Backups()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		if strings.HasPrefix(backups[i].Stamp, at) {
+			return &backups[i], nil
+		}
+	}
+
+	if at == "" {
+		return nil, errors.Errorf("There are no backups of %s in %s", program.KubeConfig, program.BackupDir)
+	}
+	return nil, errors.Errorf("There is no backup of %s at %s", program.KubeConfig, at)
+}
+
+// ListBackups writes a table of the backups and what each contains
+func (program *Options) ListBackups(out io.Writer) error {
+	backups, err := program.Backups()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TIMESTAMP\tCONTEXTS\tPROVIDERS")
+
+	for _, b := range backups {
+		config, err := clientcmd.LoadFromFile(b.Path)
+		if err != nil {
+			_, _ = fmt.Fprintf(w, "%s\t-\tunreadable: %v\n", b.Stamp, err)
+			continue
+		}
+
+		providers := make(map[string]int)
+		for _, context := range config.Contexts {
+			if m, ok := readMarker(context.Extensions); ok {
+				providers[m.Provider]++
+			} else {
+				providers["other"]++
+			}
+		}
+
+		var breakdown []string
+		for _, name := range sortedKeys(providers) {
+			breakdown = append(breakdown, fmt.Sprintf("%s=%d", name, providers[name]))
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%s\n", b.Stamp, len(config.Contexts), strings.Join(breakdown, " "))
+	}
+
+	return w.Flush()
+}
+
+// Restore replaces the kubeconfig with a backup.  The current kubeconfig is backed up first, so a restore can itself
+// be undone.
+func (program *Options) Restore(at string) error {
+	b, err := program.findBackup(at)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(b.Path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := clientcmd.Load(data); err != nil {
+		return errors.Wrapf(err, "Backup %s is not a valid kubeconfig", b.Path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(program.KubeConfig), 0700); err != nil {
+		return err
+	}
+
+	lock, err := lockFile(program.KubeConfig + ".lock")
+	if err != nil {
+		return errors.Wrap(err, "Failed to lock kubeconfig")
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			log.Warn().Err(err).Msg("Failed to release lock")
+		}
+	}()
+
+	if current, err := os.ReadFile(program.KubeConfig); err == nil {
+		if bytes.Equal(current, data) {
+			log.Info().Str("backup", b.Path).Str("kubeconfig_file", program.KubeConfig).Msg("Kubeconfig already matches the backup")
+			return nil
+		}
+		if err := program.backup(current); err != nil {
+			return errors.Wrap(err, "Failed to back up current kubeconfig")
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := WriteFileAtomic(program.KubeConfig, data); err != nil {
+		return errors.Wrap(err, "Error restoring kubeconfig")
+	}
+
+	log.Info().Str("backup", b.Path).Str("kubeconfig_file", program.KubeConfig).Msg("Restored kubeconfig")
+	return nil
+}
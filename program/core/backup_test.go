@@ -0,0 +1,106 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestBackupRotation(t *testing.T) {
+	dir := t.TempDir()
+	options := &Options{
+		KubeConfig:  filepath.Join(dir, "config"),
+		BackupDir:   filepath.Join(dir, "backups"),
+		BackupCount: 2,
+	}
+
+	count := func() int {
+		t.Helper()
+		backups, err := options.Backups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(backups)
+	}
+	write := func(server string) {
+		t.Helper()
+		time.Sleep(2 * time.Millisecond) // backups are stamped to the millisecond
+		config := api.NewConfig()
+		config.Clusters["c"] = &api.Cluster{Server: server}
+		if err := options.WriteConfig(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var restored string
+	restore := func(at string) {
+		t.Helper()
+		time.Sleep(2 * time.Millisecond)
+		b, err := options.findBackup(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored = b.Stamp
+		if err := options.Restore(at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, step := range []struct {
+		name    string
+		run     func()
+		backups int
+		server  string
+	}{
+		{name: "new file", run: func() { write("https://a") }, backups: 0, server: "https://a"},
+		{name: "change", run: func() { write("https://b") }, backups: 1, server: "https://b"},
+		{name: "no change", run: func() { write("https://b") }, backups: 1, server: "https://b"},
+		{name: "no change again", run: func() { write("https://b") }, backups: 1, server: "https://b"},
+		{name: "second change", run: func() { write("https://c") }, backups: 2, server: "https://c"},
+		{name: "rotation", run: func() { write("https://d") }, backups: 2, server: "https://d"},
+		{name: "restore", run: func() { restore("") }, backups: 2, server: "https://c"},
+		{name: "restore without change", run: func() { restore(restored) }, backups: 2, server: "https://c"},
+	} {
+		step.run()
+
+		config, err := options.ReadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := count(); got != step.backups || config.Clusters["c"].Server != step.server {
+			t.Errorf("%s: got %d backups and server %s, want %d and %s",
+				step.name, got, config.Clusters["c"].Server, step.backups, step.server)
+		}
+	}
+}
+
+func TestBackupSkipsIdentical(t *testing.T) {
+	dir := t.TempDir()
+	options := &Options{KubeConfig: filepath.Join(dir, "config"), BackupDir: dir, BackupCount: 10}
+
+	for _, data := range []string{"a", "a", "b", "a"} {
+		time.Sleep(2 * time.Millisecond)
+		if err := options.backup([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := options.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range backups {
+		data, err := os.ReadFile(b.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+	}
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "a" {
+		t.Errorf("got backups %q, want only the changes", got)
+	}
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"os"
+	"path/filepath"
+)
+
+// captureConfig adds the entry to the kubeconfig, marking everything it creates as owned by kuconf.  Unless
+// overwrite is set, entries which already exist only have the fields kuconf manages updated: the server and
+// certificate authority of the cluster, the exec command and credentials of the user and the cluster and user of the
+// context.  The namespace of an existing context is only changed if forceNamespace is set.  Existing entries kuconf
+// created for another cluster are never taken over, and neither are ones it didn't create unless adopt is set: the
+// name clash is returned as an error instead.
+func captureConfig(e *Entry, m Marker, i *api.Config, overwrite, forceNamespace, adopt bool) error {
+	if err := checkExisting(e, m, i, adopt); err != nil {
+		return err
+	}
+
+	cluster, user := e.Cluster, e.AuthInfo
+	context := &api.Context{
+		Cluster:   e.ClusterName,
+		AuthInfo:  e.AuthInfoName,
+		Namespace: e.Namespace,
+	}
+
+	if existing, found := i.Clusters[e.ClusterName]; found && !overwrite {
+		cluster = existing.DeepCopy()
+		cluster.Server = e.Cluster.Server
+		cluster.CertificateAuthorityData = e.Cluster.CertificateAuthorityData
+		cluster.CertificateAuthority = e.Cluster.CertificateAuthority
+	}
+
+	if existing, found := i.AuthInfos[e.AuthInfoName]; found && !overwrite {
+		user = existing.DeepCopy()
+		user.Exec = mergeExec(existing.Exec, e.AuthInfo.Exec, e.ManagedEnv)
+		user.ClientCertificateData = e.AuthInfo.ClientCertificateData
+		user.ClientKeyData = e.AuthInfo.ClientKeyData
+		user.Token = e.AuthInfo.Token
+	}
+
+	if existing, found := i.Contexts[e.Name]; found && !overwrite {
+		context = existing.DeepCopy()
+		context.Cluster = e.ClusterName
+		context.AuthInfo = e.AuthInfoName
+		if forceNamespace && e.Namespace != "" {
+			context.Namespace = e.Namespace
+		}
+	}
+
+	for _, extensions := range []*map[string]runtime.Object{&cluster.Extensions, &user.Extensions, &context.Extensions} {
+		if *extensions == nil {
+			*extensions = make(map[string]runtime.Object)
+		}
+		(*extensions)[ExtensionName] = m.extension()
+	}
+
+	i.Clusters[e.ClusterName] = cluster
+	i.AuthInfos[e.AuthInfoName] = user
+	i.Contexts[e.Name] = context
+
+	return nil
+}
+
+// checkExisting returns an error if the entry's context, cluster or user already exists with the marker of another
+// cluster, such as one captured from another account in an earlier run, or without a kuconf marker unless adopt is set
+func checkExisting(e *Entry, m Marker, i *api.Config, adopt bool) error {
+	existing := []struct {
+		kind, name string
+		extensions map[string]runtime.Object
+		found      bool
+	}{
+		{kind: "context", name: e.Name},
+		{kind: "cluster", name: e.ClusterName},
+		{kind: "user", name: e.AuthInfoName},
+	}
+	if c, found := i.Contexts[e.Name]; found {
+		existing[0].extensions, existing[0].found = c.Extensions, true
+	}
+	if c, found := i.Clusters[e.ClusterName]; found {
+		existing[1].extensions, existing[1].found = c.Extensions, true
+	}
+	if u, found := i.AuthInfos[e.AuthInfoName]; found {
+		existing[2].extensions, existing[2].found = u.Extensions, true
+	}
+
+	for _, x := range existing {
+		if !x.found {
+			continue
+		}
+		owner, ok := readMarker(x.extensions)
+		if !ok && adopt {
+			continue
+		} else if !ok {
+			return errors.Errorf("Name collision: %s %s already exists and wasn't created by kuconf, use --adopt to take it over",
+				x.kind, x.name)
+		}
+		if owner != m {
+			return errors.Errorf("Name collision: %s %s already belongs to %s", x.kind, x.name, owner)
+		}
+	}
+
+	return nil
+}
+
+// mergeExec returns the exec configuration kuconf generated, keeping any environment variables added to the
+// existing one which kuconf doesn't set or manage itself
+func mergeExec(existing, generated *api.ExecConfig, managedEnv []string) *api.ExecConfig {
+	if existing == nil || generated == nil {
+		return generated
+	}
+
+	exec := generated.DeepCopy()
+
+	managed := make(map[string]bool)
+	for _, name := range managedEnv {
+		managed[name] = true
+	}
+	for _, env := range generated.Env {
+		managed[env.Name] = true
+	}
+
+	for _, env := range existing.Env {
+		if !managed[env.Name] {
+			exec.Env = append(exec.Env, env)
+		}
+	}
+
+	return exec
+}
+
+// load reads the existing configuration, either from the kubeconfig file or the output directory
+func (program *Options) load() (*api.Config, error) {
+	if program.OutputDir != "" {
+		return program.readDir()
+	}
+	return program.ReadConfig()
+}
+
+// save writes the configuration, either to the kubeconfig file or as one file per captured context in the output
+// directory.  The destination is locked and read again, and only the changes this run made to the original
+// configuration are applied to it, so that edits made while discovery was running are not lost.
+func (program *Options) save(original, after *api.Config, captured map[string]bool) error {
+	lockPath := program.KubeConfig + ".lock"
+	if program.OutputDir != "" {
+		if err := os.MkdirAll(program.OutputDir, 0700); err != nil {
+			return err
+		}
+		lockPath = filepath.Join(program.OutputDir, ".kuconf.lock")
+	} else if err := os.MkdirAll(filepath.Dir(program.KubeConfig), 0700); err != nil {
+		return err
+	}
+
+	lock, err := lockFile(lockPath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to lock kubeconfig")
+	}
+	defer func() {
+		if err := lock.Unlock(); err != nil {
+			log.Warn().Err(err).Str("lock_file", lockPath).Msg("Failed to release lock")
+		}
+	}()
+
+	current, err := program.load()
+	if err != nil {
+		return err
+	}
+
+	merged := mergeChanges(original, after, current)
+
+	if program.OutputDir != "" {
+		return program.writeDir(current, merged, captured)
+	}
+	return program.WriteConfig(merged)
+}
+
+// ReadConfig reads the kubeconfig file.  Relative paths in the file are left as they are, so they are written back
+// unchanged.
+func (program *Options) ReadConfig() (*api.Config, error) {
+	data, err := os.ReadFile(program.KubeConfig)
+	if os.IsNotExist(err) {
+		return api.NewConfig(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return clientcmd.Load(data)
+}
+
+// WriteConfig saves the config to the kubeconfig file, keeping the previous file as a timestamped backup.  Both are
+// written to a unique temporary file and renamed into place, so the kubeconfig is never missing or partially written.
+// Nothing is written or backed up if the file wouldn't change, so runs without changes don't rotate out backups.
+func (program *Options) WriteConfig(config *api.Config) error {
+	log := log.With().Str("kubeconfig_file", program.KubeConfig).Logger()
+
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+
+	if existing, err := os.ReadFile(program.KubeConfig); err == nil {
+		// Keep the comments and layout of the existing file
+		if data, err = patchYAML(existing, data); err != nil {
+			return errors.Wrap(err, "Failed to update kubeconfig")
+		}
+
+		if bytes.Equal(data, existing) {
+			log.Debug().Msg("Kubeconfig is unchanged, not writing it")
+			return nil
+		}
+
+		if err := program.backup(existing); err != nil {
+			return errors.Wrap(err, "Failed to save config backup file")
+		}
+	} else if os.IsNotExist(err) {
+		log.Debug().Msg("No existing config file.  Creating a new one")
+	} else {
+		return err
+	}
+
+	if err := WriteFileAtomic(program.KubeConfig, data); err != nil {
+		return errors.Wrap(err, "Error saving new kubeconfig")
+	}
+
+	return nil
+}
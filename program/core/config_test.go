@@ -0,0 +1,202 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+const prodArn = "arn:aws:eks:eu-west-1:111111111111:cluster/prod"
+
+var prodMarker = Marker{Provider: "aws", Account: "111111111111", Region: "eu-west-1", Name: "prod"}
+
+// awsEntry is an entry as the AWS provider renders it
+func awsEntry(name, arn, server, profile string) *Entry {
+	return &Entry{
+		Name:         name,
+		ClusterName:  arn,
+		AuthInfoName: arn,
+		Namespace:    "apps",
+		Cluster:      &api.Cluster{Server: server, CertificateAuthorityData: []byte("new-ca")},
+		AuthInfo: &api.AuthInfo{Exec: &api.ExecConfig{
+			APIVersion: "client.authentication.k8s.io/v1beta1",
+			Command:    "aws",
+			Args:       []string{"--region", "eu-west-1", "eks", "get-token", "--cluster-name", name},
+			Env:        []api.ExecEnvVar{{Name: "AWS_PROFILE", Value: profile}},
+		}},
+		ManagedEnv: []string{"AWS_PROFILE"},
+	}
+}
+
+func TestCaptureConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name                      string
+		overwrite, forceNamespace bool
+	}{
+		{name: "update"},
+		{name: "force-namespace", forceNamespace: true},
+		{name: "overwrite", overwrite: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			existing := readTestdata(t, "existing.yaml")
+			config, err := clientcmd.Load(existing)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			devArn := "arn:aws:eks:eu-west-1:111111111111:cluster/dev"
+			devMarker := prodMarker
+			devMarker.Name = "dev"
+
+			for _, c := range []struct {
+				entry *Entry
+				m     Marker
+			}{
+				{awsEntry("prod", prodArn, "https://new.eks.amazonaws.com", "new-profile"), prodMarker},
+				{awsEntry("dev", devArn, "https://dev.eks.amazonaws.com", "new-profile"), devMarker},
+			} {
+				if err := captureConfig(c.entry, c.m, config, tc.overwrite, tc.forceNamespace, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := clientcmd.Write(*config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := patchYAML(existing, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, "capture-"+tc.name+".golden.yaml", got)
+		})
+	}
+}
+
+func TestCaptureConfigCollisions(t *testing.T) {
+	other := prodMarker
+	other.Account = "222222222222"
+
+	for _, tc := range []struct {
+		name  string
+		entry *Entry
+		m     Marker
+		adopt bool
+		err   string
+	}{
+		{name: "same cluster", entry: awsEntry("prod", prodArn, "https://new", "p"), m: prodMarker},
+		{name: "new names", entry: awsEntry("dev", "dev-arn", "https://dev", "p"), m: prodMarker},
+		{name: "unmarked context", entry: awsEntry("staging", "new-arn", "https://new", "p"), m: prodMarker,
+			err: "context staging already exists and wasn't created by kuconf"},
+		{name: "unmarked cluster", entry: awsEntry("new", "staging", "https://new", "p"), m: prodMarker,
+			err: "cluster staging already exists and wasn't created by kuconf"},
+		{name: "another account", entry: awsEntry("prod", prodArn, "https://new", "p"), m: other,
+			err: "context prod already belongs to aws cluster prod in account 111111111111"},
+		{name: "adopt unmarked", entry: awsEntry("staging", "staging", "https://new", "p"), m: prodMarker, adopt: true},
+		{name: "adopt leaves other clusters' entries", entry: awsEntry("prod", prodArn, "https://new", "p"), m: other, adopt: true,
+			err: "context prod already belongs to aws cluster prod in account 111111111111"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := clientcmd.Load(readTestdata(t, "existing.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := config.DeepCopy()
+
+			err = captureConfig(tc.entry, tc.m, config, true, true, tc.adopt)
+			switch {
+			case tc.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Fatalf("got error %v, want %q", err, tc.err)
+			case tc.err != "" && !apiEqual(before, config):
+				t.Fatal("the kubeconfig was changed despite the collision")
+			case tc.err == "":
+				for _, extensions := range []map[string]runtime.Object{
+					config.Contexts[tc.entry.Name].Extensions,
+					config.Clusters[tc.entry.ClusterName].Extensions,
+					config.AuthInfos[tc.entry.AuthInfoName].Extensions,
+				} {
+					if m, _ := readMarker(extensions); m != tc.m {
+						t.Errorf("got marker %+v, want %+v", m, tc.m)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCaptureConfigSameName(t *testing.T) {
+	// Two AKS clusters with the same name in different resource groups of one subscription and location
+	a := Marker{Provider: "azure", Account: "sub", Region: "eastus", Name: "prod",
+		ID: "/subscriptions/sub/resourcegroups/rg-a/providers/microsoft.containerservice/managedclusters/prod"}
+	b := a
+	b.ID = "/subscriptions/sub/resourcegroups/rg-b/providers/microsoft.containerservice/managedclusters/prod"
+
+	entry := func(server string) *Entry {
+		return &Entry{
+			Name:         "prod",
+			ClusterName:  "prod",
+			AuthInfoName: "clusterUser_prod",
+			Cluster:      &api.Cluster{Server: server},
+			AuthInfo:     &api.AuthInfo{Exec: &api.ExecConfig{Command: "kubelogin"}},
+		}
+	}
+
+	config := api.NewConfig()
+	if err := captureConfig(entry("https://a"), a, config, false, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	err := captureConfig(entry("https://b"), b, config, false, false, false)
+	if err == nil || !strings.Contains(err.Error(), "context prod already belongs to azure cluster prod") {
+		t.Fatalf("got error %v, want a collision", err)
+	}
+	if server := config.Clusters["prod"].Server; server != "https://a" {
+		t.Errorf("got server %s, the first cluster was overwritten", server)
+	}
+
+	// The first cluster is still recognized as the owner in later runs
+	if err := captureConfig(entry("https://a2"), a, config, false, false, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeExec(t *testing.T) {
+	env := func(names ...string) []api.ExecEnvVar {
+		var out []api.ExecEnvVar
+		for _, n := range names {
+			out = append(out, api.ExecEnvVar{Name: n, Value: "v"})
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		name                string
+		existing, generated []api.ExecEnvVar
+		managed             []string
+		want                string
+	}{
+		{name: "keeps added", existing: env("AWS_PROFILE", "HTTPS_PROXY"), generated: env("AWS_PROFILE"), want: "AWS_PROFILE,HTTPS_PROXY"},
+		{name: "drops managed", existing: env("AWS_PROFILE", "HTTPS_PROXY"), managed: []string{"AWS_PROFILE"}, want: "HTTPS_PROXY"},
+		{name: "no existing env", generated: env("AWS_PROFILE"), want: "AWS_PROFILE"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			merged := mergeExec(&api.ExecConfig{Command: "old", Env: tc.existing}, &api.ExecConfig{Command: "new", Env: tc.generated}, tc.managed)
+			var names []string
+			for _, e := range merged.Env {
+				names = append(names, e.Name)
+			}
+			if got := strings.Join(names, ","); got != tc.want || merged.Command != "new" {
+				t.Errorf("got command %s and env %s, want new and %s", merged.Command, got, tc.want)
+			}
+		})
+	}
+
+	if mergeExec(&api.ExecConfig{Command: "old"}, nil, nil) != nil {
+		t.Error("a missing generated exec must remove the existing one")
+	}
+}
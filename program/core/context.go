@@ -0,0 +1,53 @@
+package core
+
+import (
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"path"
+)
+
+// namespaceFor returns the default namespace for a new context of the cluster: the value of the namespace tag if the
+// cluster has it, otherwise the default namespace
+func (program *Options) namespaceFor(c Cluster) string {
+	if program.NamespaceTag != "" {
+		if ns := c.Tags[program.NamespaceTag]; ns != "" {
+			return ns
+		}
+	}
+	return program.DefaultNamespace
+}
+
+// selectCurrentContext sets the current context to the context matching the --current-context pattern.  An exact
+// name wins over a glob match, and if the glob matches several contexts the first in alphabetical order is used.
+func (program *Options) selectCurrentContext(config *api.Config) {
+	pattern := program.CurrentContext
+	if pattern == "" {
+		return
+	}
+
+	if _, found := config.Contexts[pattern]; found {
+		config.CurrentContext = pattern
+		return
+	}
+
+	var matches []string
+	for _, name := range sortedKeys(config.Contexts) {
+		if ok, err := path.Match(pattern, name); err != nil {
+			log.Warn().Err(err).Str("pattern", pattern).Msg("Invalid current context pattern")
+			return
+		} else if ok {
+			matches = append(matches, name)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		log.Warn().Str("pattern", pattern).Msg("No context matches the current context pattern")
+		return
+	case 1:
+	default:
+		log.Warn().Str("pattern", pattern).Strs("matches", matches).Str("context", matches[0]).Msg("Several contexts match the current context pattern, using the first")
+	}
+
+	config.CurrentContext = matches[0]
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"github.com/rs/zerolog"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Provider is a cloud that kuconf can discover clusters in.  Each provider plugs into the same pipeline, which takes
+// care of de-duplicating accounts, fanning out the work and saving the results.
+type Provider interface {
+	// Name is the short name of the provider (aws, gcp or azure)
+	Name() string
+
+	// Stats returns the statistics collected for the provider
+	Stats() *Stats
+
+	// Accounts discovers a session for every account the provider can reach.  The same account may be returned more
+	// than once (e.g. several AWS profiles for one account); only the first one is used.
+	Accounts() <-chan Session
+
+	// Expand expands an account session into one session for each location that should be scanned
+	Expand(s Session) <-chan Session
+
+	// Clusters sends every cluster visible to the session to the channel.  It returns an error unless every cluster
+	// in the session's account and region was found.
+	Clusters(s Session, clusters chan<- Cluster) error
+
+	// Render creates the kubeconfig entry for a cluster
+	Render(c Cluster) (*Entry, error)
+}
+
+// AllRegions is the region of a session which scans every region of its account at once
+const AllRegions = "*"
+
+// Session is a connection to a single account in a single location, or in every location if its region is AllRegions
+type Session interface {
+	Account() string
+	Region() string
+	Logger() zerolog.Logger
+}
+
+// PartialSession is a session which may only list some of the clusters in its account and region, e.g. because it is
+// limited to some resource groups.  Its scans are not used for pruning when Partial returns true.
+type PartialSession interface {
+	Partial() bool
+}
+
+// Cluster is a cluster found by a provider
+type Cluster struct {
+	Provider string
+	Account  string
+	Region   string
+	Name     string
+	// ID identifies the cluster uniquely within the provider, e.g. the EKS ARN, GKE self link or AKS resource ID,
+	// since the name alone may be shared by clusters in different resource groups
+	ID   string
+	Tags map[string]string
+	// Status is the provider's state of the cluster, e.g. ACTIVE for EKS, RUNNING for GKE or Succeeded for AKS
+	Status  string
+	Session Session
+	Log     zerolog.Logger
+
+	// Detail is the provider's own description of the cluster, used when rendering the entry
+	Detail any
+}
+
+// SkipError is returned by Render for a cluster which can't be captured with the current options.  The cluster is
+// counted as skipped rather than as an error, and its existing entries are kept.
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string { return e.Reason }
+
+// Entry is the kubeconfig information for a single cluster
+type Entry struct {
+	// Name is the name of the context
+	Name         string
+	ClusterName  string
+	AuthInfoName string
+	// Namespace is the default namespace of the context.  It is filled in by the pipeline, not the provider.
+	Namespace string
+	Cluster   *api.Cluster
+	AuthInfo  *api.AuthInfo
+	// ManagedEnv are exec environment variables the provider manages even when it doesn't set them, so they are
+	// removed from existing entries
+	ManagedEnv []string
+}
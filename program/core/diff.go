@@ -0,0 +1,149 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"sort"
+	"strings"
+)
+
+// Diff describes what a run changes in the kubeconfig
+type Diff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []Change `json:"changed,omitempty"`
+}
+
+// Change is a single changed field of a context which exists both before and after the run
+type Change struct {
+	Context string `json:"context"`
+	Field   string `json:"field"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// Fields reported in a Change
+const (
+	FieldEndpoint             = "endpoint"
+	FieldCertificateAuthority = "certificate-authority"
+	FieldExecCommand          = "exec-command"
+	FieldExecArgs             = "exec-args"
+)
+
+// Empty returns true if there are no changes
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// diffConfigs compares the contexts of two kubeconfigs
+func diffConfigs(before, after *api.Config) *Diff {
+	d := &Diff{}
+
+	for _, name := range sortedKeys(after.Contexts) {
+		if _, found := before.Contexts[name]; !found {
+			d.Added = append(d.Added, name)
+			continue
+		}
+
+		d.Changed = append(d.Changed, diffContext(name, before, after)...)
+	}
+
+	for _, name := range sortedKeys(before.Contexts) {
+		if _, found := after.Contexts[name]; !found {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+
+	return d
+}
+
+// diffContext compares what a context resolves to in two kubeconfigs
+func diffContext(name string, before, after *api.Config) []Change {
+	var changes []Change
+
+	add := func(field, old, new string) {
+		changes = append(changes, Change{Context: name, Field: field, Old: old, New: new})
+	}
+
+	oldCluster, newCluster := before.Clusters[before.Contexts[name].Cluster], after.Clusters[after.Contexts[name].Cluster]
+	if oldCluster != nil && newCluster != nil {
+		if oldCluster.Server != newCluster.Server {
+			add(FieldEndpoint, oldCluster.Server, newCluster.Server)
+		}
+		if !bytes.Equal(oldCluster.CertificateAuthorityData, newCluster.CertificateAuthorityData) ||
+			oldCluster.CertificateAuthority != newCluster.CertificateAuthority {
+			add(FieldCertificateAuthority, "", "")
+		}
+	}
+
+	oldUser, newUser := before.AuthInfos[before.Contexts[name].AuthInfo], after.AuthInfos[after.Contexts[name].AuthInfo]
+	if oldUser != nil && newUser != nil {
+		oldExec, newExec := oldUser.Exec, newUser.Exec
+		if oldExec == nil {
+			oldExec = &api.ExecConfig{}
+		}
+		if newExec == nil {
+			newExec = &api.ExecConfig{}
+		}
+		if oldExec.Command != newExec.Command {
+			add(FieldExecCommand, oldExec.Command, newExec.Command)
+		}
+		if strings.Join(oldExec.Args, " ") != strings.Join(newExec.Args, " ") {
+			add(FieldExecArgs, strings.Join(oldExec.Args, " "), strings.Join(newExec.Args, " "))
+		}
+	}
+
+	return changes
+}
+
+// Write writes the diff to out in the given format (text or json)
+func (d *Diff) Write(out io.Writer, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(d)
+	}
+
+	if d.Empty() {
+		_, err := fmt.Fprintln(out, "No changes")
+		return err
+	}
+
+	for _, name := range d.Added {
+		if _, err := fmt.Fprintf(out, "+ %s\n", name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range d.Removed {
+		if _, err := fmt.Fprintf(out, "- %s\n", name); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range d.Changed {
+		var err error
+		if c.Field == FieldCertificateAuthority {
+			_, err = fmt.Fprintf(out, "~ %s: certificate authority rotated\n", c.Context)
+		} else {
+			_, err = fmt.Fprintf(out, "~ %s: %s %q -> %q\n", c.Context, c.Field, c.Old, c.New)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
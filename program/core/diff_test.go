@@ -0,0 +1,62 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	config := func(server, ca string, args ...string) *api.Config {
+		c := api.NewConfig()
+		c.Clusters["c"] = &api.Cluster{Server: server, CertificateAuthorityData: []byte(ca)}
+		c.AuthInfos["u"] = &api.AuthInfo{Exec: &api.ExecConfig{Command: "aws", Args: args}}
+		c.Contexts["prod"] = &api.Context{Cluster: "c", AuthInfo: "u"}
+		return c
+	}
+
+	withContext := func(c *api.Config, name string) *api.Config {
+		c.Contexts[name] = &api.Context{Cluster: "c", AuthInfo: "u"}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name          string
+		before, after *api.Config
+		want          string
+	}{
+		{name: "no changes", before: config("https://a", "ca"), after: config("https://a", "ca"), want: "No changes\n"},
+		{name: "added", before: config("https://a", "ca"), after: withContext(config("https://a", "ca"), "dev"), want: "+ dev\n"},
+		{name: "removed", before: withContext(config("https://a", "ca"), "dev"), after: config("https://a", "ca"), want: "- dev\n"},
+		{name: "endpoint", before: config("https://a", "ca"), after: config("https://b", "ca"),
+			want: "~ prod: endpoint \"https://a\" -> \"https://b\"\n"},
+		{name: "certificate authority", before: config("https://a", "ca"), after: config("https://a", "new-ca"),
+			want: "~ prod: certificate authority rotated\n"},
+		{name: "exec args", before: config("https://a", "ca", "--region", "a"), after: config("https://a", "ca", "--region", "b"),
+			want: "~ prod: exec-args \"--region a\" -> \"--region b\"\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := diffConfigs(tc.before, tc.after).Write(&buf, "text"); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("got %q, want %q", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	before, after := api.NewConfig(), api.NewConfig()
+	after.Contexts["dev"] = &api.Context{}
+
+	buf := bytes.Buffer{}
+	if err := diffConfigs(before, after).Write(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"added\": [\n    \"dev\"\n  ]\n}\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// filter decides which discovered clusters are captured
+type filter struct {
+	include, exclude []func(string) bool
+	tags             map[string]*string
+	statuses         map[string]bool
+}
+
+// filter compiles the filter options
+func (program *Options) filter() (*filter, error) {
+	f := &filter{
+		tags:     make(map[string]*string),
+		statuses: make(map[string]bool),
+	}
+
+	var err error
+	if f.include, err = patterns(program.IncludeName); err != nil {
+		return nil, errors.Wrap(err, "Invalid --include-name")
+	}
+	if f.exclude, err = patterns(program.ExcludeName); err != nil {
+		return nil, errors.Wrap(err, "Invalid --exclude-name")
+	}
+
+	for _, t := range program.Tag {
+		if key, value, found := strings.Cut(t, "="); found {
+			f.tags[key] = &value
+		} else {
+			f.tags[t] = nil
+		}
+	}
+
+	for _, s := range program.Status {
+		f.statuses[strings.ToLower(s)] = true
+	}
+
+	return f, nil
+}
+
+// patterns compiles name patterns.  A pattern between slashes is a regular expression, anything else is a glob.
+func patterns(in []string) ([]func(string) bool, error) {
+	var out []func(string) bool
+	for _, p := range in {
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, re.MatchString)
+		} else {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, errors.Wrap(err, p)
+			}
+			out = append(out, func(name string) bool {
+				matched, _ := path.Match(p, name)
+				return matched
+			})
+		}
+	}
+	return out, nil
+}
+
+// skip returns why the cluster is filtered out, or "" if it is to be captured
+func (f *filter) skip(c Cluster) string {
+	if len(f.include) > 0 && !matchesAny(f.include, c.Name) {
+		return "name is not included"
+	}
+	if matchesAny(f.exclude, c.Name) {
+		return "name is excluded"
+	}
+
+	for key, value := range f.tags {
+		actual, found := c.Tags[key]
+		switch {
+		case !found:
+			return fmt.Sprintf("no %s tag", key)
+		case value != nil && actual != *value:
+			return fmt.Sprintf("%s tag is %q", key, actual)
+		}
+	}
+
+	if len(f.statuses) > 0 && !f.statuses[strings.ToLower(c.Status)] {
+		return fmt.Sprintf("status is %s", c.Status)
+	}
+
+	return ""
+}
+
+func matchesAny(patterns []func(string) bool, name string) bool {
+	for _, match := range patterns {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package core
+
+import "testing"
+
+func TestFilterSkip(t *testing.T) {
+	cluster := Cluster{Name: "prod-eu", Status: "ACTIVE", Tags: map[string]string{"env": "prod", "team": "payments"}}
+
+	for _, tc := range []struct {
+		name    string
+		options Options
+		skipped bool
+	}{
+		{name: "no filters", options: Options{}},
+		{name: "included glob", options: Options{IncludeName: []string{"dev-*", "prod-*"}}},
+		{name: "not included", options: Options{IncludeName: []string{"dev-*"}}, skipped: true},
+		{name: "included regex", options: Options{IncludeName: []string{"/^prod-(eu|us)$/"}}},
+		{name: "excluded", options: Options{ExcludeName: []string{"*-eu"}}, skipped: true},
+		{name: "exclude wins", options: Options{IncludeName: []string{"prod-*"}, ExcludeName: []string{"/eu/"}}, skipped: true},
+		{name: "tag key", options: Options{Tag: []string{"team"}}},
+		{name: "missing tag", options: Options{Tag: []string{"owner"}}, skipped: true},
+		{name: "tag value", options: Options{Tag: []string{"env=prod", "team=payments"}}},
+		{name: "other tag value", options: Options{Tag: []string{"env=dev"}}, skipped: true},
+		{name: "tag value with equals", options: Options{Tag: []string{"env=prod=1"}}, skipped: true},
+		{name: "status ignores case", options: Options{Status: []string{"active", "running"}}},
+		{name: "other status", options: Options{Status: []string{"CREATING"}}, skipped: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := tc.options.filter()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reason := f.skip(cluster); (reason != "") != tc.skipped {
+				t.Errorf("skip returned %q, want skipped %v", reason, tc.skipped)
+			}
+		})
+	}
+}
+
+func TestFilterInvalidPatterns(t *testing.T) {
+	for _, options := range []Options{
+		{IncludeName: []string{"/(/"}},
+		{ExcludeName: []string{"[a-"}},
+	} {
+		if _, err := options.filter(); err == nil {
+			t.Errorf("%+v must be an error", options)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"github.com/rs/zerolog/log"
+	"os"
+)
+
+// fileLock is an advisory lock held on a lock file, so that concurrent kuconf runs don't write the same kubeconfig at
+// the same time
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile takes an exclusive lock on path, creating it if needed, and waits for any other holder to release it
+func lockFile(path string) (*fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := tryLock(f)
+	if err == nil && !locked {
+		log.Info().Str("lock_file", path).Msg("Waiting for another kuconf run to finish")
+		err = lock(f)
+	}
+
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &fileLock{file: f}, nil
+}
+
+// Unlock releases the lock
+func (l *fileLock) Unlock() error {
+	err := unlock(l.file)
+	if e2 := l.file.Close(); err == nil {
+		err = e2
+	}
+	return err
+}
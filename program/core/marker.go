@@ -0,0 +1,71 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ExtensionName is the name of the kubeconfig extension used to mark the entries kuconf created
+const ExtensionName = "kuconf"
+
+// Marker records where a kuconf-owned kubeconfig entry was discovered
+type Marker struct {
+	Provider string `json:"provider"`
+	Account  string `json:"account"`
+	Region   string `json:"region"`
+	Name     string `json:"name"`
+	ID       string `json:"id,omitempty"`
+}
+
+// markerFor returns the marker for a discovered cluster
+func markerFor(c Cluster) Marker {
+	return Marker{
+		Provider: c.Provider,
+		Account:  c.Account,
+		Region:   c.Region,
+		Name:     c.Name,
+		ID:       c.ID,
+	}
+}
+
+// String describes the marked cluster for messages
+func (m Marker) String() string {
+	s := fmt.Sprintf("%s cluster %s in account %s, region %s", m.Provider, m.Name, m.Account, m.Region)
+	if m.ID != "" && m.ID != m.Name {
+		s += " (" + m.ID + ")"
+	}
+	return s
+}
+
+// extension returns the marker as a kubeconfig extension object
+func (m Marker) extension() runtime.Object {
+	data, _ := json.Marshal(m)
+	return &runtime.Unknown{Raw: data, ContentType: runtime.ContentTypeJSON}
+}
+
+// readMarker returns the kuconf marker from an entry's extensions, if there is one
+func readMarker(extensions map[string]runtime.Object) (Marker, bool) {
+	var m Marker
+
+	u, ok := extensions[ExtensionName].(*runtime.Unknown)
+	if !ok {
+		return m, false
+	}
+
+	if err := json.Unmarshal(u.Raw, &m); err != nil || m.Provider == "" {
+		return m, false
+	}
+
+	return m, true
+}
+
+// scope is a single account and region of a provider
+type scope struct {
+	provider, account, region string
+}
+
+// scope returns the account and region the marked entry was discovered in
+func (m Marker) scope() scope {
+	return scope{m.Provider, m.Account, m.Region}
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"k8s.io/client-go/tools/clientcmd/api"
+	"reflect"
+)
+
+// mergeChanges applies the changes this run made (the difference between original and after) to current, the
+// kubeconfig as it is on disk now.  Edits made by someone else while discovery was running are kept unless this run
+// changed the same entry.
+func mergeChanges(original, after, current *api.Config) *api.Config {
+	merged := current.DeepCopy()
+
+	mergeMap(original.Clusters, after.Clusters, merged.Clusters)
+	mergeMap(original.AuthInfos, after.AuthInfos, merged.AuthInfos)
+	mergeMap(original.Contexts, after.Contexts, merged.Contexts)
+
+	if after.CurrentContext != original.CurrentContext {
+		merged.CurrentContext = after.CurrentContext
+	}
+
+	return merged
+}
+
+func mergeMap[V any](original, after, current map[string]V) {
+	for name, value := range after {
+		if old, found := original[name]; !found || !reflect.DeepEqual(old, value) {
+			current[name] = value
+		}
+	}
+
+	for name := range original {
+		if _, found := after[name]; !found {
+			delete(current, name)
+		}
+	}
+}
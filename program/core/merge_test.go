@@ -0,0 +1,89 @@
+package core
+
+import (
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestMergeChanges(t *testing.T) {
+	config := func(current string, servers map[string]string) *api.Config {
+		c := api.NewConfig()
+		c.CurrentContext = current
+		for name, server := range servers {
+			c.Clusters[name] = &api.Cluster{Server: server}
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		name                     string
+		original, after, current *api.Config
+		want                     *api.Config
+	}{
+		{
+			name:     "keeps edits made meanwhile",
+			original: config("", map[string]string{"a": "1"}),
+			after:    config("", map[string]string{"a": "1", "b": "2"}),
+			current:  config("", map[string]string{"a": "1", "edited": "x"}),
+			want:     config("", map[string]string{"a": "1", "b": "2", "edited": "x"}),
+		},
+		{
+			name:     "run changes win for the same entry",
+			original: config("", map[string]string{"a": "1"}),
+			after:    config("", map[string]string{"a": "2"}),
+			current:  config("", map[string]string{"a": "edited"}),
+			want:     config("", map[string]string{"a": "2"}),
+		},
+		{
+			name:     "unchanged entries keep the edit",
+			original: config("", map[string]string{"a": "1"}),
+			after:    config("", map[string]string{"a": "1"}),
+			current:  config("", map[string]string{"a": "edited"}),
+			want:     config("", map[string]string{"a": "edited"}),
+		},
+		{
+			name:     "removals are applied",
+			original: config("", map[string]string{"a": "1", "gone": "1"}),
+			after:    config("", map[string]string{"a": "1"}),
+			current:  config("", map[string]string{"a": "1", "gone": "1"}),
+			want:     config("", map[string]string{"a": "1"}),
+		},
+		{
+			name:     "current context only when changed",
+			original: config("a", nil),
+			after:    config("a", nil),
+			current:  config("edited", nil),
+			want:     config("edited", nil),
+		},
+		{
+			name:     "changed current context",
+			original: config("a", nil),
+			after:    config("b", nil),
+			current:  config("edited", nil),
+			want:     config("b", nil),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := mergeChanges(tc.original, tc.after, tc.current); !apiEqual(got, tc.want) {
+				t.Errorf("got clusters %v and current context %q", servers(got), got.CurrentContext)
+			}
+		})
+	}
+}
+
+func servers(c *api.Config) map[string]string {
+	out := make(map[string]string)
+	for name, cluster := range c.Clusters {
+		out[name] = cluster.Server
+	}
+	return out
+}
+
+// apiEqual compares two kubeconfigs by their serialized form
+func apiEqual(a, b *api.Config) bool {
+	da, errA := clientcmd.Write(*a)
+	db, errB := clientcmd.Write(*b)
+	return errA == nil && errB == nil && string(da) == string(db)
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"strings"
+	"text/template"
+)
+
+// namer renders the names of kubeconfig entries from the naming templates.  The templates are executed against the
+// Cluster, so they can use fields like {{.Provider}}, {{.Account}}, {{.Region}}, {{.Name}} and {{.Tags.env}}.
+type namer struct {
+	context, cluster, user *template.Template
+
+	// owners records which cluster each name was given to in this run
+	owners map[string]Marker
+}
+
+func (program *Options) namer() (*namer, error) {
+	n := &namer{owners: make(map[string]Marker)}
+
+	for _, t := range []struct {
+		name, text string
+		tmpl       **template.Template
+	}{
+		{"context", program.ContextTemplate, &n.context},
+		{"cluster", program.ClusterTemplate, &n.cluster},
+		{"user", program.UserTemplate, &n.user},
+	} {
+		if t.text == "" {
+			continue
+		}
+
+		tmpl, err := template.New(t.name).Option("missingkey=zero").Parse(t.text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid %s template", t.name)
+		}
+		*t.tmpl = tmpl
+	}
+
+	return n, nil
+}
+
+// apply renames the entry according to the templates.  Names the templates don't cover keep the provider's default.
+func (n *namer) apply(c Cluster, e *Entry) error {
+	for _, t := range []struct {
+		tmpl *template.Template
+		name *string
+	}{
+		{n.context, &e.Name},
+		{n.cluster, &e.ClusterName},
+		{n.user, &e.AuthInfoName},
+	} {
+		if t.tmpl == nil {
+			continue
+		}
+
+		buf := bytes.Buffer{}
+		if err := t.tmpl.Execute(&buf, c); err != nil {
+			return errors.Wrapf(err, "Failed to render %s name", t.tmpl.Name())
+		}
+
+		name := strings.TrimSpace(buf.String())
+		if name == "" {
+			return errors.Errorf("The %s template rendered an empty name", t.tmpl.Name())
+		}
+		*t.name = name
+	}
+
+	return nil
+}
+
+// claim records the entry's names as belonging to the marked cluster.  It returns an error if another cluster
+// found in this run already has one of the names, so that neither entry silently overwrites the other.
+func (n *namer) claim(e *Entry, m Marker) error {
+	keys := []string{"context/" + e.Name, "cluster/" + e.ClusterName, "user/" + e.AuthInfoName}
+
+	for _, key := range keys {
+		if owner, found := n.owners[key]; found && owner != m {
+			return errors.Errorf("Name collision: %s is also used by %s", key, owner)
+		}
+	}
+
+	for _, key := range keys {
+		n.owners[key] = m
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNamerApply(t *testing.T) {
+	n, err := (&Options{ContextTemplate: "{{.Provider}}-{{.Account}}-{{.Name}}", UserTemplate: "{{.Tags.team}}"}).namer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Entry{Name: "prod", ClusterName: "arn", AuthInfoName: "arn"}
+	c := Cluster{Provider: "aws", Account: "111", Name: "prod", Tags: map[string]string{"team": "payments"}}
+	if err := n.apply(c, e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "aws-111-prod" || e.ClusterName != "arn" || e.AuthInfoName != "payments" {
+		t.Errorf("got names %s, %s, %s", e.Name, e.ClusterName, e.AuthInfoName)
+	}
+
+	c.Tags = nil
+	if err := n.apply(c, e); err == nil {
+		t.Error("an empty name must be an error")
+	}
+
+	if _, err := (&Options{ClusterTemplate: "{{.Name"}).namer(); err == nil {
+		t.Error("an invalid template must be an error")
+	}
+}
+
+func TestNamerClaim(t *testing.T) {
+	a := Marker{Provider: "aws", Account: "111", Region: "eu-west-1", Name: "prod"}
+	b := Marker{Provider: "aws", Account: "222", Region: "eu-west-1", Name: "prod"}
+	// AKS cluster names are only unique within a resource group
+	aksA := Marker{Provider: "azure", Account: "sub", Region: "eastus", Name: "prod",
+		ID: "/subscriptions/sub/resourcegroups/rg-a/providers/microsoft.containerservice/managedclusters/prod"}
+	aksB := aksA
+	aksB.ID = "/subscriptions/sub/resourcegroups/rg-b/providers/microsoft.containerservice/managedclusters/prod"
+	entry := func(context, cluster, user string) *Entry {
+		return &Entry{Name: context, ClusterName: cluster, AuthInfoName: user}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		claims []*Entry
+		owners []Marker
+		err    string
+	}{
+		{name: "different names", claims: []*Entry{entry("a", "a", "a"), entry("b", "b", "b")}, owners: []Marker{a, b}},
+		{name: "same cluster twice", claims: []*Entry{entry("a", "a", "a"), entry("a", "a", "a")}, owners: []Marker{a, a}},
+		{name: "context", claims: []*Entry{entry("prod", "a", "a"), entry("prod", "b", "b")}, owners: []Marker{a, b},
+			err: "context/prod is also used by aws cluster prod in account 111"},
+		{name: "cluster", claims: []*Entry{entry("a", "shared", "a"), entry("b", "shared", "b")}, owners: []Marker{a, b},
+			err: "cluster/shared"},
+		{name: "user", claims: []*Entry{entry("a", "a", "shared"), entry("b", "b", "shared")}, owners: []Marker{a, b},
+			err: "user/shared"},
+		{name: "same name in another resource group", claims: []*Entry{entry("prod", "prod", "prod"), entry("prod", "prod", "prod")},
+			owners: []Marker{aksA, aksB}, err: "context/prod is also used by azure cluster prod in account sub, region eastus (" + aksA.ID + ")"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n, _ := (&Options{}).namer()
+
+			var err error
+			for i, e := range tc.claims {
+				if err = n.claim(e, tc.owners[i]); err != nil {
+					break
+				}
+			}
+
+			switch {
+			case tc.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Fatalf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"github.com/mattn/go-colorable"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"runtime"
+)
+
+// Options are the program options shared by every provider
+type Options struct {
+	KubeConfig string `group:"Input" short:"k" help:"Kubeconfig file" type:"path" default:"~/.kube/config"`
+
+	CurrentContext   string `group:"Context" help:"Make the context matching this name or glob the current context after the update"`
+	DefaultNamespace string `group:"Context" help:"Default namespace for new contexts"`
+	NamespaceTag     string `group:"Context" help:"Cluster tag or label holding the default namespace for new contexts"`
+	ForceNamespace   bool   `group:"Context" help:"Also set the namespace of existing contexts"`
+
+	BackupDir   string `group:"Backup" help:"Directory to keep kubeconfig backups in" type:"path" default:"~/.kube/kuconf-backups"`
+	BackupCount int    `group:"Backup" help:"Number of kubeconfig backups to keep" default:"10"`
+
+	IncludeName []string `group:"Filter" help:"Only capture clusters whose name matches one of these globs, or regular expressions between slashes"`
+	ExcludeName []string `group:"Filter" help:"Don't capture clusters whose name matches one of these globs, or regular expressions between slashes"`
+	Tag         []string `group:"Filter" help:"Only capture clusters with this tag or label, given as key=value, or just key for any value" sep:"none"`
+	Status      []string `group:"Filter" help:"Only capture clusters in one of these states, e.g. ACTIVE (EKS), RUNNING (GKE) or Succeeded (AKS)"`
+
+	ContextTemplate string `group:"Naming" help:"Template for context names, e.g. {{.Provider}}-{{.Account}}-{{.Name}}.  Uses the provider's naming if not set"`
+	ClusterTemplate string `group:"Naming" help:"Template for cluster names.  Uses the provider's naming if not set"`
+	UserTemplate    string `group:"Naming" help:"Template for user names.  Uses the provider's naming if not set"`
+
+	OutputDir       string `group:"Output" help:"Write one kubeconfig file per cluster into this directory instead of updating the kubeconfig file" type:"path"`
+	PrintKubeconfig bool   `group:"Output" help:"Print the KUBECONFIG path list of the files in the output directory"`
+	Prune           bool   `group:"Output" help:"Remove entries kuconf created for clusters which no longer exist"`
+	Overwrite       bool   `group:"Output" help:"Replace existing entries completely instead of only updating the fields kuconf manages"`
+	Adopt           bool   `group:"Output" help:"Take over existing entries kuconf didn't create when their names match a discovered cluster, such as ones written before kuconf marked its entries"`
+	DryRun          bool   `group:"Output" help:"Show what would change in the kubeconfig without writing it"`
+	DiffFormat      string `group:"Output" enum:"text,json" default:"text" help:"Format of the dry run output (text|json)"`
+
+	Debug        bool   `group:"Info" help:"Show debugging information"`
+	OutputFormat string `group:"Info" enum:"auto,jsonl,terminal" default:"auto" help:"How to show program output (auto|terminal|jsonl)"`
+	Quiet        bool   `group:"Info" help:"Be less verbose than usual"`
+
+	// LogToStderr is set by commands which print their result on stdout
+	LogToStderr bool `kong:"-"`
+}
+
+// InitLogging sets up the global logger according to the options
+func (program *Options) InitLogging() {
+	switch {
+	case program.Debug:
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	case program.Quiet:
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	default:
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+
+	// A dry run, --print-kubeconfig and some commands print their results on stdout, so keep the log out of the way
+	file := os.Stdout
+	if program.DryRun || program.PrintKubeconfig || program.LogToStderr {
+		file = os.Stderr
+	}
+
+	var out io.Writer = file
+
+	if os.Getenv("TERM") == "" && runtime.GOOS == "windows" {
+		out = colorable.NewColorable(file)
+	}
+
+	if program.OutputFormat == "terminal" ||
+		(program.OutputFormat == "auto" && isTerminal(file)) {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: out})
+	} else {
+		log.Logger = log.Output(out)
+	}
+}
+
+// isTerminal returns true if the file given points to a character device (i.e. a terminal)
+func isTerminal(file *os.File) bool {
+	if fileInfo, err := file.Stat(); err != nil {
+		log.Err(err).Msg("Error running stat")
+		return false
+	} else {
+		return (fileInfo.Mode() & os.ModeCharDevice) != 0
+	}
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readDir loads every kubeconfig file in the output directory into a single config
+func (program *Options) readDir() (*api.Config, error) {
+	config := api.NewConfig()
+
+	files, err := filepath.Glob(filepath.Join(program.OutputDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := clientcmd.Load(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read %s", file)
+		}
+
+		for name, cluster := range c.Clusters {
+			config.Clusters[name] = cluster
+		}
+		for name, user := range c.AuthInfos {
+			config.AuthInfos[name] = user
+		}
+		for name, context := range c.Contexts {
+			config.Contexts[name] = context
+		}
+	}
+
+	return config, nil
+}
+
+// writeDir writes one kubeconfig file for every context captured in this run and removes the files of contexts
+// which were pruned
+func (program *Options) writeDir(before, after *api.Config, captured map[string]bool) error {
+	for _, name := range sortedKeys(after.Contexts) {
+		if !captured[name] {
+			continue
+		}
+
+		context := after.Contexts[name]
+		c := api.NewConfig()
+		c.Contexts[name] = context
+		c.Clusters[context.Cluster] = after.Clusters[context.Cluster]
+		c.AuthInfos[context.AuthInfo] = after.AuthInfos[context.AuthInfo]
+		c.CurrentContext = name
+
+		if err := writeAtomic(c, program.contextFile(name)); err != nil {
+			return errors.Wrapf(err, "Failed to write kubeconfig for %s", name)
+		}
+	}
+
+	for name := range before.Contexts {
+		if _, found := after.Contexts[name]; found {
+			continue
+		}
+
+		file := program.contextFile(name)
+		log.Debug().Str("file", file).Msg("Removing kubeconfig of pruned context")
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// contextFile is the file in the output directory holding the context
+func (program *Options) contextFile(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(name)
+	return filepath.Join(program.OutputDir, name+".yaml")
+}
+
+// kubeconfigPath returns the KUBECONFIG-style list of the kubeconfig files in the output directory
+func (program *Options) kubeconfigPath(config *api.Config) string {
+	var files []string
+
+	for _, name := range sortedKeys(config.Contexts) {
+		file := program.contextFile(name)
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+
+	return strings.Join(files, string(os.PathListSeparator))
+}
+
+// writeAtomic writes the config to a new file next to path and renames it into place, so readers never see a
+// partially written file
+func writeAtomic(config *api.Config, path string) error {
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(path, data)
+}
+
+// WriteFileAtomic writes the data to a unique temporary file in the same directory as path, syncs it to disk and
+// renames it into place
+func WriteFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Sync discovers every cluster reachable by the providers and saves them all to the kubeconfig in a single write
+func (program *Options) Sync(providers ...Provider) error {
+	if program.PrintKubeconfig && program.OutputDir == "" {
+		return errors.New("--print-kubeconfig requires --output-dir")
+	}
+
+	names, err := program.namer()
+	if err != nil {
+		return err
+	}
+
+	filter, err := program.filter()
+	if err != nil {
+		return err
+	}
+
+	config, err := program.load()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read kubeconfig file")
+		return err
+	}
+
+	byName := make(map[string]Provider)
+	stats := make(map[string]*Stats)
+	for _, p := range providers {
+		byName[p.Name()] = p
+		stats[p.Name()] = p.Stats()
+	}
+
+	original := config.DeepCopy()
+
+	scanned := newScans()
+	discovered := make(map[Marker]bool)
+	captured := make(map[string]bool)
+
+	for c := range DiscoverAll(scanned, providers...) {
+		p := byName[c.Provider]
+		m := markerFor(c)
+		discovered[m] = true
+
+		if reason := filter.skip(c); reason != "" {
+			p.Stats().Skipped.Add(1)
+			c.Log.Debug().Str("reason", reason).Msg("Skipping cluster")
+			continue
+		}
+
+		entry, err := p.Render(c)
+		if err == nil {
+			err = names.apply(c, entry)
+		}
+		if err == nil {
+			err = names.claim(entry, m)
+		}
+
+		if err == nil {
+			entry.Namespace = program.namespaceFor(c)
+			err = captureConfig(entry, m, config, program.Overwrite, program.ForceNamespace, program.Adopt)
+		}
+
+		var skipped *SkipError
+		if errors.As(err, &skipped) {
+			p.Stats().Skipped.Add(1)
+			c.Log.Warn().Str("reason", skipped.Reason).Msg("Skipping cluster")
+		} else if err != nil {
+			p.Stats().Errors.Add(1)
+			c.Log.Error().Err(err).Msg("Error capturing cluster configuration")
+		} else {
+			captured[entry.Name] = true
+		}
+	}
+
+	// Discovery has finished, so providers holding clients can release them
+	for _, p := range providers {
+		if c, ok := p.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Warn().Err(err).Str("provider", p.Name()).Msg("Failed to close provider")
+			}
+		}
+	}
+
+	if program.Prune {
+		prune(config, discovered, scanned, stats)
+	}
+
+	program.selectCurrentContext(config)
+
+	if program.DryRun {
+		if err := diffConfigs(original, config).Write(os.Stdout, program.DiffFormat); err != nil {
+			log.Error().Err(err).Msg("Error writing differences")
+		}
+	} else if err := program.save(original, config, captured); err != nil {
+		for _, p := range providers {
+			p.Stats().Errors.Add(1)
+		}
+		log.Error().
+			Err(err).
+			Str("file", program.KubeConfig).
+			Str("dir", program.OutputDir).
+			Msg("Error saving kubeconfig")
+	} else if program.OutputDir != "" && program.PrintKubeconfig {
+		fmt.Println(program.kubeconfigPath(config))
+	}
+
+	var failed []string
+	for _, p := range providers {
+		p.Stats().Log()
+		if p.Stats().Errors.Load() > 0 {
+			failed = append(failed, p.Name())
+		}
+	}
+
+	if len(providers) > 1 {
+		logTotals(providers)
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("Errors encountered during run for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// DiscoverAll runs the discovery of every provider concurrently and merges the clusters found into one channel
+func DiscoverAll(scanned *scans, providers ...Provider) <-chan Cluster {
+	clusters := make(chan Cluster)
+
+	go func() {
+		wg := sync.WaitGroup{}
+
+		defer close(clusters)
+		defer wg.Wait()
+
+		for _, p := range providers {
+			wg.Add(1)
+			go func(p Provider) {
+				defer wg.Done()
+				for c := range Discover(p, scanned) {
+					clusters <- c
+				}
+			}(p)
+		}
+	}()
+
+	return clusters
+}
+
+// Discover fans out over every unique account and location of the provider and returns a channel of the clusters
+// found.  Every account and region which was scanned without errors is recorded in scanned.
+func Discover(p Provider, scanned *scans) <-chan Cluster {
+	clusters := make(chan Cluster)
+
+	go func() {
+		wg := sync.WaitGroup{}
+
+		defer close(clusters)
+		defer wg.Wait()
+
+		for account := range uniqueAccounts(p) {
+			wg.Add(1)
+			go func(account Session) {
+				defer wg.Done()
+				for s := range p.Expand(account) {
+					p.Stats().Regions.Add(1)
+					wg.Add(1)
+					go func(s Session) {
+						defer wg.Done()
+						if err := p.Clusters(s, clusters); err == nil {
+							if partial, ok := s.(PartialSession); !ok || !partial.Partial() {
+								scanned.add(scope{p.Name(), s.Account(), s.Region()})
+							}
+						}
+					}(s)
+				}
+			}(account)
+		}
+	}()
+
+	return clusters
+}
+
+// uniqueAccounts filters the provider's account sessions so that each account is only returned once
+func uniqueAccounts(p Provider) <-chan Session {
+	sessions := make(chan Session)
+
+	go func() {
+		defer close(sessions)
+
+		accounts := make(map[string]bool)
+		for s := range p.Accounts() {
+			log := s.Logger()
+			if accounts[s.Account()] {
+				log.Debug().Msg("Account is duplicate")
+				continue
+			}
+
+			log.Debug().Msg("Account is good for use")
+			accounts[s.Account()] = true
+			p.Stats().UniqueAccounts.Add(1)
+			sessions <- s
+		}
+	}()
+
+	return sessions
+}
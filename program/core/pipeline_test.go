@@ -0,0 +1,133 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+type fakeSession struct{ account string }
+
+func (s fakeSession) Account() string        { return s.account }
+func (s fakeSession) Region() string         { return "region" }
+func (s fakeSession) Logger() zerolog.Logger { return log.Logger }
+
+// fakeProvider finds the named clusters in one account and region.  Clusters named in skip can't be rendered.
+type fakeProvider struct {
+	account string
+	names   []string
+	skip    map[string]bool
+	stats   *Stats
+}
+
+func newFakeProvider(account string, names ...string) *fakeProvider {
+	return &fakeProvider{account: account, names: names, skip: make(map[string]bool), stats: NewStats("fake")}
+}
+
+func (p *fakeProvider) Name() string  { return "fake" }
+func (p *fakeProvider) Stats() *Stats { return p.stats }
+
+func (p *fakeProvider) Accounts() <-chan Session {
+	sessions := make(chan Session, 1)
+	sessions <- fakeSession{p.account}
+	close(sessions)
+	return sessions
+}
+
+func (p *fakeProvider) Expand(s Session) <-chan Session {
+	sessions := make(chan Session, 1)
+	sessions <- s
+	close(sessions)
+	return sessions
+}
+
+func (p *fakeProvider) Clusters(s Session, clusters chan<- Cluster) error {
+	for _, name := range p.names {
+		clusters <- Cluster{Provider: "fake", Account: p.account, Region: "region", Name: name, Session: s, Log: log.Logger}
+	}
+	return nil
+}
+
+func (p *fakeProvider) Render(c Cluster) (*Entry, error) {
+	if p.skip[c.Name] {
+		return nil, &SkipError{Reason: "not supported"}
+	}
+	return &Entry{
+		Name:         c.Name,
+		ClusterName:  c.Name,
+		AuthInfoName: c.Name,
+		Cluster:      &api.Cluster{Server: "https://" + c.Name},
+		AuthInfo:     &api.AuthInfo{Token: "token"},
+	}, nil
+}
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	options := &Options{
+		KubeConfig:  filepath.Join(dir, "new", "config"),
+		BackupDir:   filepath.Join(dir, "backups"),
+		BackupCount: 3,
+		Prune:       true,
+	}
+
+	read := func() *api.Config {
+		t.Helper()
+		config, err := options.ReadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	// The first run creates the kubeconfig's directory
+	if err := options.Sync(newFakeProvider("a", "prod", "dev")); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(read().Contexts); got != 2 {
+		t.Fatalf("got %d contexts, want 2", got)
+	}
+
+	// A cluster which is skipped keeps its entry, isn't an error and isn't pruned
+	p := newFakeProvider("a", "prod", "dev")
+	p.skip["dev"] = true
+	if err := options.Sync(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.stats.Skipped.Load() != 1 || read().Contexts["dev"] == nil {
+		t.Errorf("skipped %d clusters, dev context kept: %v", p.stats.Skipped.Load(), read().Contexts["dev"] != nil)
+	}
+
+	// The same name from another account is a collision, and leaves the entry alone
+	other := newFakeProvider("b", "prod")
+	if err := options.Sync(other); err == nil || other.stats.Errors.Load() != 1 {
+		t.Errorf("got error %v and %d errors for a collision with another account", err, other.stats.Errors.Load())
+	}
+
+	// Hand-written entries are never taken over or pruned
+	config := read()
+	config.Contexts["mine"] = &api.Context{Cluster: "prod"}
+	if err := options.WriteConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := options.Sync(newFakeProvider("a", "mine")); err == nil {
+		t.Error("capturing over a hand-written context must be an error")
+	}
+	if err := options.Sync(newFakeProvider("a")); err != nil {
+		t.Fatal(err)
+	}
+	if config := read(); len(config.Contexts) != 1 || config.Contexts["mine"] == nil {
+		t.Errorf("got contexts %v, want only the hand-written one after pruning", sortedKeys(config.Contexts))
+	}
+
+	// With --adopt, a hand-written entry with the name of a discovered cluster is marked and kept up to date
+	options.Adopt = true
+	if err := options.Sync(newFakeProvider("a", "mine")); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := readMarker(read().Contexts["mine"].Extensions); !ok || m.Name != "mine" {
+		t.Errorf("got marker %+v for the adopted context", m)
+	}
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"strings"
+	"sync"
+)
+
+// scans records which accounts and regions were scanned successfully, i.e. where every cluster is known to have
+// been found
+type scans struct {
+	sync.Mutex
+	done map[scope]bool
+}
+
+func newScans() *scans {
+	return &scans{done: make(map[scope]bool)}
+}
+
+func (s *scans) add(sc scope) {
+	s.Lock()
+	defer s.Unlock()
+	s.done[sc] = true
+}
+
+// covers returns true if the marked entry's account and region were scanned.  A scan of every region covers them
+// all, and a scan of a region also covers the zones in it, which are named after their region, e.g. us-central1-a.
+func (s *scans) covers(m Marker) bool {
+	s.Lock()
+	defer s.Unlock()
+	for sc := range s.done {
+		if sc.provider != m.Provider || sc.account != m.Account {
+			continue
+		}
+		if sc.region == AllRegions || sc.region == m.Region || strings.HasPrefix(m.Region, sc.region+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes the kuconf-owned entries for clusters which were not rediscovered in a successful scan of their
+// account and region.  Entries without a kuconf marker are never touched.
+func prune(config *api.Config, discovered map[Marker]bool, scanned *scans, stats map[string]*Stats) {
+	stale := func(m Marker, ok bool) bool {
+		return ok && !discovered[m] && scanned.covers(m)
+	}
+
+	for name, context := range config.Contexts {
+		m, ok := readMarker(context.Extensions)
+		if !stale(m, ok) {
+			continue
+		}
+
+		log.Info().
+			Str("context", name).
+			Str("provider", m.Provider).
+			Str("account", m.Account).
+			Str("region", m.Region).
+			Msg("Pruning context for cluster which no longer exists")
+
+		delete(config.Contexts, name)
+		if config.CurrentContext == name {
+			config.CurrentContext = ""
+		}
+		if s, found := stats[m.Provider]; found {
+			s.Pruned.Add(1)
+		}
+	}
+
+	clusters := make(map[string]bool)
+	users := make(map[string]bool)
+	for _, context := range config.Contexts {
+		clusters[context.Cluster] = true
+		users[context.AuthInfo] = true
+	}
+
+	for name, cluster := range config.Clusters {
+		if m, ok := readMarker(cluster.Extensions); stale(m, ok) && !clusters[name] {
+			delete(config.Clusters, name)
+		}
+	}
+
+	for name, user := range config.AuthInfos {
+		if m, ok := readMarker(user.Extensions); stale(m, ok) && !users[name] {
+			delete(config.AuthInfos, name)
+		}
+	}
+}
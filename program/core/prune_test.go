@@ -0,0 +1,91 @@
+package core
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestScansCovers(t *testing.T) {
+	s := newScans()
+	s.add(scope{"aws", "111", "eu-west-1"})
+	s.add(scope{"gcp", "project", AllRegions})
+	s.add(scope{"gcp", "regional", "us-central1"})
+
+	for _, tc := range []struct {
+		m    Marker
+		want bool
+	}{
+		{Marker{Provider: "aws", Account: "111", Region: "eu-west-1"}, true},
+		{Marker{Provider: "aws", Account: "111", Region: "us-east-1"}, false},
+		{Marker{Provider: "aws", Account: "222", Region: "eu-west-1"}, false},
+		{Marker{Provider: "gcp", Account: "project", Region: "europe-west1-b"}, true},
+		{Marker{Provider: "gcp", Account: "other", Region: "europe-west1-b"}, false},
+		{Marker{Provider: "gcp", Account: "regional", Region: "us-central1"}, true},
+		{Marker{Provider: "gcp", Account: "regional", Region: "us-central1-a"}, true},
+		{Marker{Provider: "gcp", Account: "regional", Region: "us-central10-a"}, false},
+		{Marker{Provider: "gcp", Account: "regional", Region: "us-east1-b"}, false},
+		{Marker{Provider: "azure", Account: "111", Region: "eu-west-1"}, false},
+	} {
+		if got := s.covers(tc.m); got != tc.want {
+			t.Errorf("covers(%+v) = %v, want %v", tc.m, got, tc.want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	marked := func(m Marker) map[string]runtime.Object {
+		return map[string]runtime.Object{ExtensionName: m.extension()}
+	}
+	gone := Marker{Provider: "aws", Account: "111", Region: "eu-west-1", Name: "gone"}
+	found := Marker{Provider: "aws", Account: "111", Region: "eu-west-1", Name: "found"}
+	unscanned := Marker{Provider: "aws", Account: "111", Region: "us-east-1", Name: "unscanned"}
+	shared := Marker{Provider: "aws", Account: "111", Region: "eu-west-1", Name: "shared"}
+
+	config := api.NewConfig()
+	config.CurrentContext = "gone"
+	for _, m := range []Marker{gone, found, unscanned} {
+		config.Clusters[m.Name] = &api.Cluster{Extensions: marked(m)}
+		config.AuthInfos[m.Name] = &api.AuthInfo{Extensions: marked(m)}
+		config.Contexts[m.Name] = &api.Context{Cluster: m.Name, AuthInfo: m.Name, Extensions: marked(m)}
+	}
+	// Not created by kuconf, although it looks stale
+	config.Contexts["hand-written"] = &api.Context{Cluster: "gone-cluster"}
+	config.Clusters["gone-cluster"] = &api.Cluster{}
+	// A stale cluster still used by a context someone added by hand
+	config.Clusters["shared"] = &api.Cluster{Extensions: marked(shared)}
+	config.Contexts["uses-shared"] = &api.Context{Cluster: "shared"}
+
+	scanned := newScans()
+	scanned.add(scope{"aws", "111", "eu-west-1"})
+	stats := map[string]*Stats{"aws": NewStats("aws")}
+
+	prune(config, map[Marker]bool{found: true}, scanned, stats)
+
+	for _, tc := range []struct {
+		kind, name string
+		exists     bool
+		want       bool
+	}{
+		{"context", "gone", config.Contexts["gone"] != nil, false},
+		{"cluster", "gone", config.Clusters["gone"] != nil, false},
+		{"user", "gone", config.AuthInfos["gone"] != nil, false},
+		{"context", "found", config.Contexts["found"] != nil, true},
+		{"context", "unscanned", config.Contexts["unscanned"] != nil, true},
+		{"context", "hand-written", config.Contexts["hand-written"] != nil, true},
+		{"cluster", "gone-cluster", config.Clusters["gone-cluster"] != nil, true},
+		{"cluster", "shared", config.Clusters["shared"] != nil, true},
+	} {
+		if tc.exists != tc.want {
+			t.Errorf("%s %s exists: %v, want %v", tc.kind, tc.name, tc.exists, tc.want)
+		}
+	}
+
+	if config.CurrentContext != "" {
+		t.Errorf("current context is %q after pruning it", config.CurrentContext)
+	}
+	if got := stats["aws"].Pruned.Load(); got != 1 {
+		t.Errorf("pruned %d contexts, want 1", got)
+	}
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"github.com/rs/zerolog/log"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats are the counters collected for one provider during a run
+type Stats struct {
+	Provider string
+
+	Accounts, UniqueAccounts, UsableAccounts, Regions, Clusters, Skipped, Pruned, Errors atomic.Int32
+
+	// Retries and Throttled count the API calls retried, and those of them which were throttled
+	Retries, Throttled atomic.Int32
+
+	choicesMutex sync.Mutex
+	choices      []CredentialChoice
+}
+
+// CredentialChoice records which of the credentials reaching an account was used, and why
+type CredentialChoice struct {
+	Account  string
+	Chosen   string
+	Rejected []string
+	Rule     string
+}
+
+// AddChoice records the credential chosen for an account, to be reported with the statistics
+func (s *Stats) AddChoice(c CredentialChoice) {
+	s.choicesMutex.Lock()
+	defer s.choicesMutex.Unlock()
+	s.choices = append(s.choices, c)
+}
+
+// NewStats creates the statistics for the named provider
+func NewStats(provider string) *Stats {
+	return &Stats{Provider: provider}
+}
+
+func (s *Stats) Log() {
+	log.Info().
+		Str("provider", s.Provider).
+		Int32("accounts", s.Accounts.Load()).
+		Int32("unique_accounts", s.UniqueAccounts.Load()).
+		Int32("usable_accounts", s.UsableAccounts.Load()).
+		Int32("regions", s.Regions.Load()).
+		Int32("clusters", s.Clusters.Load()).
+		Int32("skipped", s.Skipped.Load()).
+		Int32("pruned", s.Pruned.Load()).
+		Int32("retries", s.Retries.Load()).
+		Int32("throttled", s.Throttled.Load()).
+		Int32("fatal_errors", s.Errors.Load()).
+		Msg("Statistics")
+
+	s.choicesMutex.Lock()
+	defer s.choicesMutex.Unlock()
+	for _, c := range s.choices {
+		log.Info().
+			Str("provider", s.Provider).
+			Str("account", c.Account).
+			Str("chosen", c.Chosen).
+			Strs("rejected", c.Rejected).
+			Str("rule", c.Rule).
+			Msg("Credential choice")
+	}
+}
+
+// logTotals logs the statistics summed over all providers
+func logTotals(providers []Provider) {
+	total := NewStats("all")
+	for _, p := range providers {
+		s := p.Stats()
+		total.Accounts.Add(s.Accounts.Load())
+		total.UniqueAccounts.Add(s.UniqueAccounts.Load())
+		total.UsableAccounts.Add(s.UsableAccounts.Load())
+		total.Regions.Add(s.Regions.Load())
+		total.Clusters.Add(s.Clusters.Load())
+		total.Skipped.Add(s.Skipped.Load())
+		total.Pruned.Add(s.Pruned.Load())
+		total.Errors.Add(s.Errors.Load())
+		total.Retries.Add(s.Retries.Load())
+		total.Throttled.Add(s.Throttled.Load())
+	}
+	total.Log()
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"go.yaml.in/yaml/v3"
+)
+
+// patchYAML applies the content of updated to the YAML document in existing, keeping the comments, key order and
+// list order of existing wherever the content didn't change.  Entries of named lists (clusters, users, contexts and
+// extensions) are matched by name.
+func patchYAML(existing, updated []byte) ([]byte, error) {
+	var old, new yaml.Node
+
+	if err := yaml.Unmarshal(existing, &old); err != nil || len(old.Content) == 0 {
+		// Nothing worth keeping in the existing file
+		return updated, nil
+	}
+
+	if err := yaml.Unmarshal(updated, &new); err != nil {
+		return nil, err
+	}
+
+	merged := mergeNode(&old, &new)
+
+	buf := bytes.Buffer{}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	encoder.CompactSeqIndent()
+
+	if err := encoder.Encode(merged); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// mergeNode returns a node with the content of new, reusing the nodes (and so the comments) of old where they match
+func mergeNode(old, new *yaml.Node) *yaml.Node {
+	switch {
+	case old.Kind != new.Kind:
+		return keepComments(old, new)
+
+	case old.Kind == yaml.DocumentNode && len(old.Content) == 1 && len(new.Content) == 1:
+		old.Content[0] = mergeNode(old.Content[0], new.Content[0])
+		return old
+
+	case old.Kind == yaml.MappingNode:
+		old.Content = mergeMapping(old.Content, new.Content)
+		return old
+
+	case old.Kind == yaml.SequenceNode && isNamedList(old) && isNamedList(new):
+		old.Content = mergeNamedList(old.Content, new.Content)
+		return old
+
+	case old.Kind == yaml.ScalarNode && old.Value == new.Value && old.Tag == new.Tag:
+		return old
+
+	default:
+		return keepComments(old, new)
+	}
+}
+
+// mergeMapping merges the key/value pairs of two mappings, keeping the order of the old keys and adding new keys at
+// the end
+func mergeMapping(old, new []*yaml.Node) []*yaml.Node {
+	values := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(new); i += 2 {
+		values[new[i].Value] = new[i+1]
+	}
+
+	var merged []*yaml.Node
+	seen := make(map[string]bool)
+
+	for i := 0; i+1 < len(old); i += 2 {
+		key := old[i].Value
+		if value, found := values[key]; found {
+			merged = append(merged, old[i], mergeNode(old[i+1], value))
+			seen[key] = true
+		}
+	}
+
+	for i := 0; i+1 < len(new); i += 2 {
+		if !seen[new[i].Value] {
+			merged = append(merged, new[i], new[i+1])
+		}
+	}
+
+	return merged
+}
+
+// mergeNamedList merges two lists of mappings with a "name" key, keeping the order of the old list and adding new
+// entries at the end
+func mergeNamedList(old, new []*yaml.Node) []*yaml.Node {
+	items := make(map[string]*yaml.Node)
+	for _, item := range new {
+		items[nameOf(item)] = item
+	}
+
+	var merged []*yaml.Node
+	seen := make(map[string]bool)
+
+	for _, item := range old {
+		name := nameOf(item)
+		if value, found := items[name]; found && !seen[name] {
+			merged = append(merged, mergeNode(item, value))
+			seen[name] = true
+		}
+	}
+
+	for _, item := range new {
+		if name := nameOf(item); !seen[name] {
+			merged = append(merged, item)
+			seen[name] = true
+		}
+	}
+
+	return merged
+}
+
+// isNamedList returns true if every item in the sequence is a mapping with a "name" key
+func isNamedList(n *yaml.Node) bool {
+	for _, item := range n.Content {
+		if item.Kind != yaml.MappingNode || nameOf(item) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func nameOf(n *yaml.Node) string {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == "name" {
+			return n.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// keepComments moves the comments of the node being replaced onto its replacement
+func keepComments(old, new *yaml.Node) *yaml.Node {
+	if new.HeadComment == "" {
+		new.HeadComment = old.HeadComment
+	}
+	if new.LineComment == "" {
+		new.LineComment = old.LineComment
+	}
+	if new.FootComment == "" {
+		new.FootComment = old.FootComment
+	}
+	return new
+}
@@ -0,0 +1,33 @@
+package core
+
+import "testing"
+
+func TestPatchYAML(t *testing.T) {
+	got, err := patchYAML(readTestdata(t, "existing.yaml"), readTestdata(t, "patch-updated.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "patch.golden.yaml", got)
+
+	// Patching the result with the same content again must not change it
+	again, err := patchYAML(got, readTestdata(t, "patch-updated.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(got) {
+		t.Errorf("patching twice changed the file:\n%s", again)
+	}
+}
+
+func TestPatchYAMLWithoutExisting(t *testing.T) {
+	updated := readTestdata(t, "patch-updated.yaml")
+	for _, existing := range []string{"", "# only a comment\n"} {
+		got, err := patchYAML([]byte(existing), updated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(updated) {
+			t.Errorf("patching %q returned\n%s", existing, got)
+		}
+	}
+}
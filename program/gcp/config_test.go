@@ -0,0 +1,42 @@
+package gcp
+
+import (
+	"encoding/base64"
+	"testing"
+
+	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/pkg/errors"
+)
+
+func TestRender(t *testing.T) {
+	program := &Options{}
+	s := &gcpSessionInfo{project: "project", location: "europe-west1"}
+	auth := &containerpb.MasterAuth{ClusterCaCertificate: base64.StdEncoding.EncodeToString([]byte("ca"))}
+
+	for _, tc := range []struct {
+		name    string
+		cluster *containerpb.Cluster
+		skipped bool
+	}{
+		{name: "running", cluster: &containerpb.Cluster{Name: "prod", Location: "europe-west1", Endpoint: "10.0.0.1", MasterAuth: auth}},
+		{name: "provisioning", cluster: &containerpb.Cluster{Name: "prod", Location: "europe-west1", Status: containerpb.Cluster_PROVISIONING},
+			skipped: true},
+		{name: "no certificate yet", cluster: &containerpb.Cluster{Name: "prod", Location: "europe-west1", Endpoint: "10.0.0.1",
+			MasterAuth: &containerpb.MasterAuth{}}, skipped: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entry, err := program.Render(core.Cluster{Name: "prod", Session: s, Detail: tc.cluster})
+
+			var skip *core.SkipError
+			switch {
+			case tc.skipped && !errors.As(err, &skip):
+				t.Fatalf("got error %v, want the cluster skipped", err)
+			case !tc.skipped && err != nil:
+				t.Fatal(err)
+			case !tc.skipped && (entry.Cluster.Server != "https://10.0.0.1" || string(entry.Cluster.CertificateAuthorityData) != "ca"):
+				t.Errorf("got cluster %+v", entry.Cluster)
+			}
+		})
+	}
+}
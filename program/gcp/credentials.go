@@ -0,0 +1,158 @@
+package gcp
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// gcloudConfiguration is a named gcloud configuration, as sections of settings
+type gcloudConfiguration map[string]map[string]string
+
+// gcloudDir is the gcloud configuration directory
+func gcloudDir() string {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return dir
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "gcloud")
+}
+
+// readConfiguration reads the named gcloud configuration
+func readConfiguration(name string) (gcloudConfiguration, error) {
+	path := filepath.Join(gcloudDir(), "configurations", "config_"+name)
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read gcloud configuration %s", name)
+	}
+	defer func() { _ = f.Close() }()
+
+	config := make(gcloudConfiguration)
+	var section map[string]string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		s := strings.TrimSpace(scanner.Text())
+		switch {
+		case s == "" || strings.HasPrefix(s, "#") || strings.HasPrefix(s, ";"):
+			continue
+		case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+			name := strings.TrimSpace(s[1 : len(s)-1])
+			if config[name] == nil {
+				config[name] = make(map[string]string)
+			}
+			section = config[name]
+		case section != nil:
+			if key, value, found := strings.Cut(s, "="); found {
+				section[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	return config, scanner.Err()
+}
+
+// configuration is the gcloud configuration named by --configuration, or nil if there isn't one
+func (program *Options) configuration() (gcloudConfiguration, error) {
+	if program.Configuration == "" {
+		return nil, nil
+	}
+	program.configurationOnce.Do(func() {
+		program.gcloud, program.gcloudErr = readConfiguration(program.Configuration)
+	})
+	return program.gcloud, program.gcloudErr
+}
+
+// credentialsFile is the credentials file for the identity every client uses, or "" for the application default
+// credentials.  A credentials file given by flag or GOOGLE_APPLICATION_CREDENTIALS wins over the gcloud
+// configuration's account, and must exist.
+func (program *Options) credentialsFile(config gcloudConfiguration) (string, error) {
+	account := config["core"]["account"]
+
+	switch {
+	case program.CredentialsFile != "":
+		if _, err := os.Stat(program.CredentialsFile); err != nil {
+			return "", errors.Wrap(err, "Failed to read the GCP credentials file")
+		}
+		if account != "" {
+			log.Debug().Str("account", account).Msg("Using the credentials file instead of the gcloud configuration's account")
+		}
+		return program.CredentialsFile, nil
+
+	case account != "":
+		// gcloud keeps application default credentials for each account it has logged in
+		file := filepath.Join(gcloudDir(), "legacy_credentials", account, "adc.json")
+		if _, err := os.Stat(file); err != nil {
+			return "", errors.Errorf("No credentials for account %s of gcloud configuration %s, run 'gcloud auth login'", account, program.Configuration)
+		}
+		return file, nil
+	}
+
+	file := filepath.Join(gcloudDir(), "application_default_credentials.json")
+	if _, err := os.Stat(file); err != nil {
+		return "", nil
+	}
+	return file, nil
+}
+
+// clientOptions are the options for every GCP API client, so that they all use the same identity.  They are worked
+// out once: the credentials file if one is given, otherwise the gcloud configuration's account if there is one,
+// otherwise gcloud's application default credentials file if it exists, otherwise the application default
+// credentials, then impersonating the service account if there is one.
+func (program *Options) clientOptions() ([]option.ClientOption, error) {
+	program.credentialsOnce.Do(func() {
+		program.options, program.credentialsErr = program.resolveCredentials()
+	})
+	return program.options, program.credentialsErr
+}
+
+func (program *Options) resolveCredentials() ([]option.ClientOption, error) {
+	config, err := program.configuration()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := program.credentialsFile(config)
+	if err != nil {
+		return nil, err
+	}
+
+	target := program.ImpersonateServiceAccount
+	if target == "" {
+		target = config["auth"]["impersonate_service_account"]
+	}
+
+	var opts []option.ClientOption
+	if file != "" {
+		log.Debug().Str("file", file).Msg("Using GCP credentials file")
+		opts = append(opts, option.WithCredentialsFile(file))
+	} else {
+		log.Debug().Msg("Using GCP application default credentials")
+	}
+
+	if target != "" {
+		log.Debug().Str("service_account", target).Msg("Impersonating GCP service account")
+		ts, err := impersonate.CredentialsTokenSource(context.Background(), impersonate.CredentialsConfig{
+			TargetPrincipal: target,
+			Scopes:          []string{cloudPlatformScope},
+		}, opts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to impersonate %s", target)
+		}
+		opts = []option.ClientOption{option.WithTokenSource(ts)}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,70 @@
+package gcp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CLOUDSDK_CONFIG", dir)
+
+	write := func(path, data string) string {
+		t.Helper()
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	given := write("key.json", "{}")
+	accountFile := write("legacy_credentials/me@example.com/adc.json", "{}")
+	write("configurations/config_work", "[core]\naccount = me@example.com\nproject = work\n\n[auth]\nimpersonate_service_account = sa@work.iam.gserviceaccount.com\n")
+	write("configurations/config_logged-out", "[core]\naccount = other@example.com\n")
+	write("configurations/config_no-account", "[core]\nproject = work\n")
+
+	for _, tc := range []struct {
+		name, file, configuration string
+		want, err                 string
+	}{
+		{name: "nothing given", want: ""},
+		{name: "credentials file", file: given, want: given},
+		{name: "configuration account", configuration: "work", want: accountFile},
+		{name: "credentials file wins over the configuration", file: given, configuration: "work", want: given},
+		{name: "configuration without account", configuration: "no-account", want: ""},
+		{name: "account not logged in", configuration: "logged-out", err: "run 'gcloud auth login'"},
+		{name: "missing credentials file", file: filepath.Join(dir, "missing.json"), configuration: "work", err: "no such file"},
+		{name: "missing configuration", configuration: "nope", err: "Failed to read gcloud configuration nope"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			program := &Options{CredentialsFile: tc.file, Configuration: tc.configuration}
+
+			config, err := program.configuration()
+			var got string
+			if err == nil {
+				got, err = program.credentialsFile(config)
+			}
+
+			switch {
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Fatalf("got error %v, want %q", err, tc.err)
+			case tc.err == "" && err != nil:
+				t.Fatal(err)
+			case got != tc.want:
+				t.Errorf("got credentials file %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	// gcloud's application default credentials are used when nothing else is given
+	adc := write("application_default_credentials.json", "{}")
+	if got, err := (&Options{}).credentialsFile(nil); err != nil || got != adc {
+		t.Errorf("got credentials file %q and error %v, want %q", got, err, adc)
+	}
+}
@@ -9,28 +9,67 @@ import (
 
 	"cloud.google.com/go/container/apiv1"
 	containerpb "cloud.google.com/go/container/apiv1/containerpb"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"google.golang.org/api/option"
 )
 
+// allLocations is the GKE location which matches every zone and region
+const allLocations = "-"
+
 type gcpSessionInfo struct {
-	project string
-	zone    string
-	session *container.ClusterManagerClient
-	log     zerolog.Logger
+	project  string
+	location string
+	session  *container.ClusterManagerClient
+	listing  *clusterListing
+	log      zerolog.Logger
+}
+
+func (s *gcpSessionInfo) Account() string        { return s.project }
+func (s *gcpSessionInfo) Logger() zerolog.Logger { return s.log }
+
+// Region is the location the session finds clusters in
+func (s *gcpSessionInfo) Region() string {
+	if s.location == allLocations {
+		return core.AllRegions
+	}
+	return s.location
+}
+
+// clusterListing is the listing of a project's clusters in every location, made once and shared by the sessions of
+// each zone and region to check
+type clusterListing struct {
+	once sync.Once
+	out  *containerpb.ListClustersResponse
+	err  error
 }
 
-type GCPClusterInfo struct {
-	*containerpb.Cluster
-	log     zerolog.Logger
-	session *gcpSessionInfo
+// matches returns true if the zone or region is in the session's location.  A region also matches its zones.
+func (s *gcpSessionInfo) matches(location string) bool {
+	return s.location == allLocations || location == s.location || strings.HasPrefix(location, s.location+"-")
 }
 
+// getProjects gets the projects from the program arguments or project file, or discovers them
 func (program *Options) getProjects() <-chan string {
 	output := make(chan string)
 
-	if len(program.Projects) < 1 {
+	switch {
+	case program.DiscoverProjects:
+		go func() {
+			defer close(output)
+			projects, err := program.discoverProjects()
+			if err != nil {
+				stats.Errors.Add(1)
+				log.Error().Err(err).Msg("Failed to discover projects")
+				return
+			}
+			for _, p := range projects {
+				output <- p
+			}
+		}()
+
+	case len(program.Projects) < 1:
 		go func() {
 			defer close(output)
 			if f, err := os.Open(program.ProjectFile); err == nil {
@@ -48,7 +87,8 @@ func (program *Options) getProjects() <-chan string {
 				log.Error().Str("file", program.ProjectFile).Err(err).Msg("Failed to open project file")
 			}
 		}()
-	} else {
+
+	default:
 		go func() {
 			defer close(output)
 			for _, p := range program.Projects {
@@ -60,83 +100,105 @@ func (program *Options) getProjects() <-chan string {
 	return output
 }
 
-func (program *Options) getClustersFrom(s *gcpSessionInfo, clusters chan<- GCPClusterInfo) {
+// Clusters gets the GKE clusters in the session's location from the project's listing of every location.  If some
+// zones of the location couldn't be reached the clusters found are still returned, along with an error so the scan
+// doesn't count as complete and nothing is pruned in the location.
+func (program *Options) Clusters(sess core.Session, clusters chan<- core.Cluster) error {
+	s := sess.(*gcpSessionInfo)
+
 	wg := sync.WaitGroup{}
 	defer wg.Wait()
 
-	req := &containerpb.ListClustersRequest{
-		Parent: "projects/" + s.project + "/locations/" + s.zone,
-	}
-
-	s.log.Debug().Str("request", req.Parent).Msg("Requesting cluster listing")
-
-	out, err := s.session.ListClusters(context.Background(), req)
+	out, err := s.list()
 	if err != nil {
+		stats.Errors.Add(1)
 		s.log.Error().Err(err).Msg("Error listing GKE clusters")
-		return
+		return err
 	}
 
-	s.log.Debug().Int("number_of_clusters", len(out.Clusters)).Msg("GKE clusters found")
-
-	if len(out.Clusters) > 0 {
-		stats.Clusters.Add(int32(len(out.Clusters)))
+	var found []*containerpb.Cluster
+	for _, c := range out.Clusters {
+		if s.matches(c.Location) {
+			found = append(found, c)
+		}
 	}
 
-	if len(out.Clusters) == 0 {
-		s.log.Warn().Msg("No GKE clusters found in the specified project and zone")
+	s.log.Debug().Int("number_of_clusters", len(found)).Msg("GKE clusters found")
+	stats.Clusters.Add(int32(len(found)))
+
+	if len(found) == 0 {
+		s.log.Warn().Msg("No GKE clusters found in the specified project and location")
 	}
 
-	for _, c := range out.Clusters {
+	for _, c := range found {
 		wg.Add(1)
 		go func(c *containerpb.Cluster) {
 			defer wg.Done()
 			s.log.Debug().Str("cluster_name", c.Name).Msg("Found GKE cluster")
-			clusters <- GCPClusterInfo{
-				Cluster: c,
-				log:     s.log.With().Str("cluster_name", c.Name).Logger(),
-				session: s,
+			clusters <- core.Cluster{
+				Provider: program.Name(),
+				Account:  s.project,
+				Region:   c.Location,
+				Name:     c.Name,
+				ID:       c.SelfLink,
+				Tags:     c.ResourceLabels,
+				Status:   c.Status.String(),
+				Session:  s,
+				Log:      s.log.With().Str("cluster_name", c.Name).Str("location", c.Location).Logger(),
+				Detail:   c,
 			}
 		}(c)
 	}
+
+	var missing []string
+	for _, zone := range out.MissingZones {
+		if s.matches(zone) {
+			missing = append(missing, zone)
+		}
+	}
+	if len(missing) > 0 {
+		s.log.Warn().Strs("missing_zones", missing).Msg("GKE clusters couldn't be listed in some zones")
+		return errors.Errorf("Clusters in zones %s couldn't be listed", strings.Join(missing, ", "))
+	}
+
+	return nil
 }
 
-func (program *Options) getUniqueGCPSessions() <-chan *gcpSessionInfo {
-	sessions := make(chan *gcpSessionInfo)
+// list lists the project's clusters in every location, only once for every session sharing the listing
+func (s *gcpSessionInfo) list() (*containerpb.ListClustersResponse, error) {
+	s.listing.once.Do(func() {
+		req := &containerpb.ListClustersRequest{
+			Parent: "projects/" + s.project + "/locations/" + allLocations,
+		}
+
+		s.log.Debug().Str("request", req.Parent).Msg("Requesting cluster listing")
+		s.listing.out, s.listing.err = s.session.ListClusters(context.Background(), req)
+	})
+	return s.listing.out, s.listing.err
+}
+
+// Expand returns the project's session, which finds clusters in every location, or a session for each of the zones
+// and regions to check if they are given.  The sessions share the project's listing.
+func (program *Options) Expand(sess core.Session) <-chan core.Session {
+	info := sess.(*gcpSessionInfo)
+	sessions := make(chan core.Session)
 
 	go func() {
-		wg := sync.WaitGroup{}
 		defer close(sessions)
-		defer wg.Wait()
 
-		projects := make(map[string]bool)
-		for info := range program.getProjectSessions() {
-			if _, found := projects[info.project]; found {
-				info.log.Debug().Msg("Project is duplicate")
-				continue
-			}
-
-			info.log.Debug().Msg("Project is good for use")
-			projects[info.project] = true
-			stats.UniqueProjects.Add(1)
+		locations := append(append([]string{}, program.Zones...), program.Regions...)
+		if len(locations) < 1 {
 			sessions <- info
+			return
+		}
 
-			if strings.Count(info.zone, "-") == 2 {
-				for _, zone := range program.Zones {
-					if zone != info.zone {
-						wg.Add(1)
-						go func(project, zone string) {
-							defer wg.Done()
-							log := log.With().Str("project", project).Str("zone", zone).Logger()
-							log.Debug().Msg("Creating regional session")
-
-							if s, err := program.newGCPSession(project, zone); err == nil {
-								sessions <- s
-							} else {
-								log.Error().Err(err).Msg("Failed to create GCP session")
-							}
-						}(info.project, zone)
-					}
-				}
+		for _, location := range locations {
+			sessions <- &gcpSessionInfo{
+				project:  info.project,
+				location: location,
+				session:  info.session,
+				listing:  info.listing,
+				log:      info.log.With().Str("location", location).Logger(),
 			}
 		}
 	}()
@@ -144,9 +206,10 @@ func (program *Options) getUniqueGCPSessions() <-chan *gcpSessionInfo {
 	return sessions
 }
 
-func (program *Options) getProjectSessions() <-chan *gcpSessionInfo {
+// Accounts gets a session for each project
+func (program *Options) Accounts() <-chan core.Session {
 
-	sessions := make(chan *gcpSessionInfo)
+	sessions := make(chan core.Session)
 	wg := sync.WaitGroup{}
 
 	go func() {
@@ -156,11 +219,13 @@ func (program *Options) getProjectSessions() <-chan *gcpSessionInfo {
 		projects := program.getProjects()
 
 		for p := range projects {
-			log := log.With().Str("project", p).Str("zone", program.Zones[0]).Logger()
+			log := log.With().Str("project", p).Logger()
+			stats.Accounts.Add(1)
 			wg.Add(1)
 			go func(p string) {
 				defer wg.Done()
-				if s, err := NewGCPSession(p, program.Zones[0], log); err == nil {
+				if s, err := program.NewGCPSession(p, allLocations, log); err == nil {
+					stats.UsableAccounts.Add(1)
 					sessions <- s
 				}
 			}(p)
@@ -170,38 +235,32 @@ func (program *Options) getProjectSessions() <-chan *gcpSessionInfo {
 	return sessions
 }
 
-func (program *Options) newGCPSession(project, zone string) (*gcpSessionInfo, error) {
-	opts := []option.ClientOption{}
-	if program.CredentialsFile != "" {
-		opts = append(opts, option.WithCredentialsFile(program.CredentialsFile))
-	}
-
-	sess, err := container.NewClusterManagerClient(context.Background(), opts...)
-	if err != nil {
-		return nil, err
-	}
-
-	stats.Projects.Add(1)
-	stats.UsableProjects.Add(1)
-
-	logger := log.With().Str("project", project).Str("zone", zone).Logger()
-	logger.Debug().Msg("GCP project session created")
-
-	return &gcpSessionInfo{
-		project: project,
-		zone:    zone,
-		session: sess,
-		log:     logger,
-	}, nil
+// clusterManager is the GKE client, shared by every session.  It can only fail to be created when the credentials
+// can't be resolved, which is the same for every project, so the failure is counted in the statistics once here.
+func (program *Options) clusterManager() (*container.ClusterManagerClient, error) {
+	program.clientOnce.Do(func() {
+		opts, err := program.clientOptions()
+		if err == nil {
+			program.client, err = container.NewClusterManagerClient(context.Background(), opts...)
+		}
+		if err != nil {
+			stats.Errors.Add(1)
+			program.clientErr = err
+		}
+	})
+	return program.client, program.clientErr
 }
 
-func NewGCPSession(project, zone string, log zerolog.Logger) (*gcpSessionInfo, error) {
-	opts := []option.ClientOption{}
-	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" {
-		opts = append(opts, option.WithCredentialsFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")))
+// Close closes the GKE client once discovery has finished
+func (program *Options) Close() error {
+	if program.client != nil {
+		return program.client.Close()
 	}
+	return nil
+}
 
-	sess, err := container.NewClusterManagerClient(context.Background(), opts...)
+func (program *Options) NewGCPSession(project, location string, log zerolog.Logger) (*gcpSessionInfo, error) {
+	sess, err := program.clusterManager()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create GCP ClusterManagerClient")
 		return nil, err
@@ -210,10 +269,10 @@ func NewGCPSession(project, zone string, log zerolog.Logger) (*gcpSessionInfo, e
 	log.Debug().Msg("GCP project session created successfully")
 
 	return &gcpSessionInfo{
-		project: project,
-		zone:    zone,
-		session: sess,
-		log:     log,
+		project:  project,
+		location: location,
+		session:  sess,
+		listing:  &clusterListing{},
+		log:      log,
 	}, nil
-
 }
@@ -0,0 +1,39 @@
+package gcp
+
+import (
+	"testing"
+
+	"github.com/clouddrove/kuconf/program/core"
+)
+
+func TestSessionMatches(t *testing.T) {
+	for _, tc := range []struct {
+		session, location string
+		want              bool
+	}{
+		{allLocations, "us-central1", true},
+		{allLocations, "us-central1-a", true},
+		{"us-central1", "us-central1", true},
+		{"us-central1", "us-central1-a", true},
+		{"us-central1", "us-central10", false},
+		{"us-central1", "us-central10-a", false},
+		{"us-central1", "us-east1-b", false},
+		{"us-central1-a", "us-central1-a", true},
+		{"us-central1-a", "us-central1", false},
+		{"us-central1-a", "us-central1-b", false},
+	} {
+		s := &gcpSessionInfo{location: tc.session}
+		if got := s.matches(tc.location); got != tc.want {
+			t.Errorf("session in %s matches %s: %v, want %v", tc.session, tc.location, got, tc.want)
+		}
+	}
+}
+
+func TestSessionRegion(t *testing.T) {
+	if got := (&gcpSessionInfo{location: allLocations}).Region(); got != core.AllRegions {
+		t.Errorf("got region %s for every location", got)
+	}
+	if got := (&gcpSessionInfo{location: "us-central1"}).Region(); got != "us-central1" {
+		t.Errorf("got region %s for us-central1", got)
+	}
+}
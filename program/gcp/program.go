@@ -1,138 +1,73 @@
 package gcp
 
 import (
-	"fmt"
-	"github.com/alecthomas/kong"
-	"github.com/mattn/go-colorable"
+	"cloud.google.com/go/container/apiv1"
+	"github.com/clouddrove/kuconf/program/core"
 	"github.com/pkg/errors"
-	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
-	"io"
-	"os"
-	"runtime"
+	"google.golang.org/api/option"
 	"sync"
 )
 
+// Options is the structure of the GCP command options
 type Options struct {
-	Version bool `help:"Show program version"`
+	CredentialsFile           string   `group:"Input" help:"GCP Credentials File, used instead of the gcloud configuration's account.  Defaults to gcloud's application default credentials" type:"path" env:"GOOGLE_APPLICATION_CREDENTIALS"`
+	ImpersonateServiceAccount string   `group:"Input" help:"Service account to impersonate for every GCP call"`
+	Configuration             string   `group:"Input" help:"Named gcloud configuration to take the account, project and impersonated service account from" env:"CLOUDSDK_ACTIVE_CONFIG_NAME"`
+	Projects                  []string `group:"Input" help:"List of GCP projects to check"`
+	ProjectFile               string   `group:"Input" help:"File containing list of GCP projects" type:"path"`
+
+	DiscoverProjects bool     `group:"Projects" help:"Check every active project visible to the credentials which has the Kubernetes Engine API enabled"`
+	Folder           string   `group:"Projects" help:"Only discover projects in this folder and the folders below it"`
+	Organization     string   `group:"Projects" help:"Only discover projects in this organization"`
+	ProjectLabel     []string `group:"Projects" help:"Only discover projects with this label, given as key=value, or just key for any value"`
+	APIConcurrency   int      `group:"Projects" help:"Maximum number of discovered projects checked for the Kubernetes Engine API at once" default:"16"`
+
+	Zones   []string `group:"Input" help:"Only check these GCP zones.  Checks every location if neither zones nor regions are given" env:"GCP_ZONES"`
+	Regions []string `group:"Input" help:"Only check these GCP regions, including their zones.  Checks every location if neither zones nor regions are given" env:"GCP_REGIONS"`
+
+	configurationOnce sync.Once           `kong:"-"`
+	gcloud            gcloudConfiguration `kong:"-"`
+	gcloudErr         error               `kong:"-"`
+
+	credentialsOnce sync.Once             `kong:"-"`
+	options         []option.ClientOption `kong:"-"`
+	credentialsErr  error                 `kong:"-"`
+
+	clientOnce sync.Once                       `kong:"-"`
+	client     *container.ClusterManagerClient `kong:"-"`
+	clientErr  error                           `kong:"-"`
+}
 
-	KubeConfig      string   `group:"Input" short:"k" help:"Kubeconfig file" type:"path" default:"~/.kube/config"`
-	CredentialsFile string   `group:"Input" short:"c" help:"GCP Credentials File" type:"existingfile" default:"~/.config/gcloud/application_default_credentials.json"`
-	Projects        []string `group:"Input" help:"List of GCP projects to check"`
-	ProjectFile     string   `group:"Input" help:"File containing list of GCP projects" type:"path"`
-	Zones           []string `group:"Input" help:"List of GCP zones to check" env:"GCP_ZONES" default:"us-central1-a,us-east1-b,us-west1-a,europe-west1-b,asia-east1-a"`
+// Name is the name of the provider
+func (program *Options) Name() string {
+	return "gcp"
+}
 
-	Debug        bool   `group:"Info" help:"Show debugging information"`
-	OutputFormat string `group:"Info" enum:"auto,jsonl,terminal" default:"auto" help:"How to show program output (auto|terminal|jsonl)"`
-	Quiet        bool   `group:"Info" help:"Be less verbose than usual"`
+// Stats returns the statistics for the provider
+func (program *Options) Stats() *core.Stats {
+	return stats
 }
 
-func (program *Options) Parse(args []string) (*kong.Context, error) {
-	parser, err := kong.New(program,
-		kong.ShortUsageOnError(),
-		kong.Description("Download kubeconfigs in bulk by examining GKE clusters across multiple projects and zones"),
-	)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
+// Run runs the program
+func (program *Options) Run(options *core.Options) error {
+	if err := program.Check(); err != nil {
+		return err
 	}
-	return parser.Parse(args)
+	return options.Sync(program)
 }
 
-func (program *Options) Run(options *Options) error {
-	config, err := program.ReadConfig()
+// Check returns an error if there is not enough configuration to run
+func (program *Options) Check() error {
+	config, err := program.configuration()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to read kubeconfig file")
 		return err
 	}
-
-	clusters := make(chan GCPClusterInfo)
-	wg := sync.WaitGroup{}
-
-	for sess := range program.getUniqueGCPSessions() {
-		wg.Add(1)
-		go func(sess *gcpSessionInfo) {
-			defer wg.Done()
-			program.getClustersFrom(sess, clusters)
-		}(sess)
-	}
-
-	go func() {
-		wg.Wait()
-		close(clusters)
-	}()
-
-	for c := range clusters {
-		if err := captureConfig(c, config); err != nil {
-			stats.Errors.Add(1)
-			log.Error().Err(err).Msg("Error capturing cluster configuration")
+	if len(program.Projects) < 1 && program.ProjectFile == "" && !program.DiscoverProjects {
+		if project := config["core"]["project"]; project != "" {
+			program.Projects = []string{project}
+		} else {
+			return errors.New("Must specify projects, a project file, a gcloud configuration with a project or --discover-projects")
 		}
 	}
-
-	if err := program.WriteConfig(config); err != nil {
-		stats.Errors.Add(1)
-		log.Error().
-			Err(err).
-			Str("file", program.KubeConfig).
-			Msg("Error saving kubeconfig")
-	}
-
-	stats.Log()
-	if stats.Errors.Load() > 0 {
-		return errors.New("Errors encountered during run")
-	}
 	return nil
 }
-
-func (program *Options) AfterApply() error {
-	program.initLogging()
-	if len(program.Zones) < 1 {
-		return errors.New("Must specify at least one zone")
-	}
-	if len(program.Projects) < 1 && program.ProjectFile == "" {
-		return errors.New("Must specify either projects or project file")
-	}
-	return nil
-}
-
-func (program *Options) initLogging() {
-	if program.Version {
-		fmt.Println(Version)
-		os.Exit(0)
-	}
-
-	switch {
-	case program.Debug:
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case program.Quiet:
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
-	default:
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	}
-
-	var out io.Writer = os.Stdout
-	if os.Getenv("TERM") == "" && runtime.GOOS == "windows" {
-		out = colorable.NewColorableStdout()
-	}
-
-	if program.OutputFormat == "terminal" ||
-		(program.OutputFormat == "auto" && isTerminal(os.Stdout)) {
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: out})
-	} else {
-		log.Logger = log.Output(out)
-	}
-
-	log.Logger.Debug().
-		Str("version", Version).
-		Str("program", os.Args[0]).
-		Msg("Starting")
-}
-
-func isTerminal(file *os.File) bool {
-	if fileInfo, err := file.Stat(); err != nil {
-		log.Err(err).Msg("Error running stat")
-		return false
-	} else {
-		return (fileInfo.Mode() & os.ModeCharDevice) != 0
-	}
-}
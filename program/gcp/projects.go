@@ -0,0 +1,151 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/api/serviceusage/v1"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// containerService is the API which must be enabled in a project for it to have GKE clusters
+const containerService = "container.googleapis.com"
+
+// discoverProjects finds the active projects visible to the credentials, within the folder or organization if one
+// is given, which have the Kubernetes Engine API enabled
+func (program *Options) discoverProjects() ([]string, error) {
+	ctx := context.Background()
+
+	opts, err := program.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	crm, err := cloudresourcemanager.NewService(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create Resource Manager client")
+	}
+
+	parents, err := program.parents(ctx, crm)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	for _, parent := range parents {
+		query := program.projectQuery(parent)
+		log.Debug().Str("query", query).Msg("Searching for projects")
+
+		err := crm.Projects.Search().Query(query).Pages(ctx, func(page *cloudresourcemanager.SearchProjectsResponse) error {
+			for _, p := range page.Projects {
+				found[p.ProjectId] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to search for projects")
+		}
+	}
+
+	candidates := make([]string, 0, len(found))
+	for p := range found {
+		candidates = append(candidates, p)
+	}
+	sort.Strings(candidates)
+
+	return program.withContainerAPI(ctx, candidates, opts), nil
+}
+
+// parents are the folder or organization and all of the folders below it, since a project search only matches
+// projects directly below the parent.  No parents means every project visible to the credentials.
+func (program *Options) parents(ctx context.Context, crm *cloudresourcemanager.Service) ([]string, error) {
+	var root string
+	switch {
+	case program.Folder != "":
+		root = "folders/" + strings.TrimPrefix(program.Folder, "folders/")
+	case program.Organization != "":
+		root = "organizations/" + strings.TrimPrefix(program.Organization, "organizations/")
+	default:
+		return []string{""}, nil
+	}
+
+	parents := []string{root}
+	for i := 0; i < len(parents); i++ {
+		err := crm.Folders.List().Parent(parents[i]).Pages(ctx, func(page *cloudresourcemanager.ListFoldersResponse) error {
+			for _, f := range page.Folders {
+				parents = append(parents, f.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to list folders in %s", parents[i])
+		}
+	}
+
+	return parents, nil
+}
+
+// projectQuery is the project search query for active projects below the parent with the project labels
+func (program *Options) projectQuery(parent string) string {
+	terms := []string{"state:ACTIVE"}
+	if parent != "" {
+		terms = append(terms, "parent:"+parent)
+	}
+	for _, l := range program.ProjectLabel {
+		if key, value, found := strings.Cut(l, "="); found {
+			terms = append(terms, fmt.Sprintf("labels.%s:%s", key, value))
+		} else {
+			terms = append(terms, fmt.Sprintf("labels.%s:*", l))
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// withContainerAPI returns the projects which have the Kubernetes Engine API enabled.  Projects are kept if the API
+// state can't be read, so that a missing Service Usage permission doesn't hide them.  At most --api-concurrency
+// projects are checked at once, to stay within the Service Usage quota in large organizations.
+func (program *Options) withContainerAPI(ctx context.Context, projects []string, opts []option.ClientOption) []string {
+	su, err := serviceusage.NewService(ctx, opts...)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to create Service Usage client, not checking which projects use GKE")
+		return projects
+	}
+
+	enabled := make([]bool, len(projects))
+	workers := make(chan struct{}, max(program.APIConcurrency, 1))
+	wg := sync.WaitGroup{}
+	for i, p := range projects {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, project string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			log := log.With().Str("project", project).Logger()
+
+			service, err := su.Services.Get(fmt.Sprintf("projects/%s/services/%s", project, containerService)).Context(ctx).Do()
+			switch {
+			case err != nil:
+				log.Warn().Err(err).Msg("Unable to check whether the Kubernetes Engine API is enabled")
+				enabled[i] = true
+			case service.State != "ENABLED":
+				log.Debug().Str("state", service.State).Msg("Skipping project without the Kubernetes Engine API")
+			default:
+				enabled[i] = true
+			}
+		}(i, p)
+	}
+	wg.Wait()
+
+	var out []string
+	for i, p := range projects {
+		if enabled[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+func TestWithContainerAPI(t *testing.T) {
+	var inFlight, most atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		switch {
+		case strings.Contains(r.URL.Path, "/denied/"):
+			http.Error(w, `{"error": {"code": 403, "message": "denied"}}`, http.StatusForbidden)
+		case strings.Contains(r.URL.Path, "/disabled-"):
+			_, _ = fmt.Fprint(w, `{"state": "DISABLED"}`)
+		default:
+			_, _ = fmt.Fprint(w, `{"state": "ENABLED"}`)
+		}
+	}))
+	defer srv.Close()
+
+	var projects []string
+	for i := 0; i < 20; i++ {
+		projects = append(projects, fmt.Sprintf("enabled-%02d", i), fmt.Sprintf("disabled-%02d", i))
+	}
+	projects = append(projects, "denied")
+
+	program := &Options{APIConcurrency: 4}
+	got := program.withContainerAPI(context.Background(), projects,
+		[]option.ClientOption{option.WithEndpoint(srv.URL), option.WithoutAuthentication()})
+
+	if len(got) != 21 || got[0] != "enabled-00" || got[20] != "denied" {
+		t.Errorf("got projects %v, want the enabled ones and the one which couldn't be checked", got)
+	}
+	if most.Load() > 4 {
+		t.Errorf("%d projects were checked at once, want at most 4", most.Load())
+	}
+}
@@ -0,0 +1,70 @@
+package program
+
+import (
+	"fmt"
+	"github.com/alecthomas/kong"
+	"github.com/clouddrove/kuconf/program/aws"
+	"github.com/clouddrove/kuconf/program/azure"
+	"github.com/clouddrove/kuconf/program/core"
+	"github.com/clouddrove/kuconf/program/gcp"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+)
+
+// Options is the structure of program options
+type Options struct {
+	core.Options `embed:""`
+
+	Version kong.VersionFlag `help:"Show program version"`
+
+	AWS   aws.Command   `cmd:"" name:"aws" help:"Download kubeconfigs for EKS clusters across multiple profiles and regions"`
+	GCP   gcp.Options   `cmd:"" name:"gcp" help:"Download kubeconfigs for GKE clusters across multiple projects and locations"`
+	Azure azure.Options `cmd:"" name:"azure" help:"Download kubeconfigs for AKS clusters across multiple subscriptions and locations"`
+	All   AllCmd        `cmd:"" name:"all" help:"Download kubeconfigs for every configured cloud in a single run"`
+
+	Backups BackupsCmd `cmd:"" help:"Manage kubeconfig backups"`
+	Restore RestoreCmd `cmd:"" help:"Restore the kubeconfig from a backup"`
+}
+
+// Parse calls the CLI parsing routines
+func (program *Options) Parse(args []string) (*kong.Context, error) {
+	parser, err := kong.New(program,
+		kong.ShortUsageOnError(),
+		kong.Bind(&program.Options),
+		kong.Description("Download kubeconfigs in bulk by examining clusters across multiple clouds, accounts and regions"),
+		kong.Vars{
+			"version":                Version,
+			"aws_regions":            aws.DefaultRegions,
+			"aws_regions_cache":      filepath.Join(cacheDir(), "aws-regions.json"),
+			"azure_environment_file": filepath.Join(cacheDir(), "azure-environment.json"),
+		},
+	)
+
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	return parser.Parse(args)
+}
+
+// AfterApply runs after the options are parsed but before anything runs
+func (program *Options) AfterApply() error {
+	program.InitLogging()
+
+	log.Logger.Debug().
+		Str("version", Version).
+		Str("program", os.Args[0]).
+		Msg("Starting")
+
+	return nil
+}
+
+// cacheDir is the directory kuconf keeps its caches in
+func cacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "kuconf")
+	}
+	return filepath.Join("~", ".cache", "kuconf")
+}
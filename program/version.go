@@ -0,0 +1,6 @@
+package program
+
+// Version is created by the Makefile and passed in as a linker flag.  When go 1.18 is released, this will be replaced
+// with the built-in mechanism
+
+var Version = "unknown"